@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
 	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/proxy"
@@ -14,24 +16,42 @@ import (
 )
 
 var (
-	connectURL   string
-	connectDebug bool
+	connectURL       string
+	connectDebug     bool
+	connectTransport string
+	connectMaxConc   int
+	connectMaxBytes  int
+	connectInclude   []string
+	connectExclude   []string
+	connectGrace     time.Duration
+	connectTimeout   time.Duration
+	connectMethodTO  map[string]string
+	connectToolTO    map[string]string
 )
 
 var connectCmd = &cobra.Command{
 	Use:   "connect",
-	Short: "Start the proxy (stdio <-> MCP server over SSE)",
+	Short: "Start the proxy (stdio <-> MCP server over SSE or Streamable HTTP)",
 	RunE:  runConnect,
 }
 
 func init() {
 	connectCmd.Flags().StringVar(&connectURL, "url", config.DefaultSSEURL, "MCP server SSE endpoint URL (e.g. http://localhost:8080/sse)")
 	connectCmd.Flags().BoolVar(&connectDebug, "debug", false, "Enable debug logging to stderr")
+	connectCmd.Flags().StringVar(&connectTransport, "transport", config.TransportAuto, "Transport: sse | streamable-http | auto (probe streamable-http, fall back to sse)")
+	connectCmd.Flags().IntVar(&connectMaxConc, "max-concurrency", config.DefaultMaxConcurrency, "Maximum number of requests forwarded to the server at the same time")
+	connectCmd.Flags().IntVar(&connectMaxBytes, "max-message-bytes", config.DefaultMaxMessageBytes, "Maximum size of a single JSON-RPC message read from stdin; larger messages get an error response")
+	connectCmd.Flags().StringSliceVar(&connectInclude, "include-notification", nil, "Forward only server notifications whose method matches one of these patterns (e.g. notifications/progress)")
+	connectCmd.Flags().StringSliceVar(&connectExclude, "exclude-notification", nil, "Do not forward server notifications whose method matches one of these patterns (e.g. notifications/message)")
+	connectCmd.Flags().DurationVar(&connectGrace, "shutdown-grace", config.DefaultShutdownGrace, "How long to wait for in-flight requests after stdin closes or a signal arrives")
+	connectCmd.Flags().DurationVar(&connectTimeout, "timeout", config.DefaultRequestTimeout, "Default time to wait for the response to a request (0 waits forever)")
+	connectCmd.Flags().StringToStringVar(&connectMethodTO, "method-timeout", nil, "Timeouts per JSON-RPC method (e.g. tools/list=10s)")
+	connectCmd.Flags().StringToStringVar(&connectToolTO, "tool-timeout", nil, "Timeouts per tool name for tools/call (e.g. search_documents=2m)")
 	_ = viper.BindPFlag("url", connectCmd.Flags().Lookup("url"))
 	_ = viper.BindPFlag("debug", connectCmd.Flags().Lookup("debug"))
 }
 
-func runConnect(_ *cobra.Command, _ []string) error {
+func runConnect(cmd *cobra.Command, _ []string) error {
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
@@ -42,6 +62,34 @@ func runConnect(_ *cobra.Command, _ []string) error {
 		cfg.URL = v
 	}
 	cfg.Debug = viper.GetBool("debug")
+	if cmd.Flags().Changed("transport") {
+		cfg.Transport = connectTransport
+	}
+	if cmd.Flags().Changed("max-concurrency") {
+		cfg.MaxConcurrency = connectMaxConc
+	}
+	if cmd.Flags().Changed("max-message-bytes") {
+		cfg.MaxMessageBytes = connectMaxBytes
+	}
+	if cmd.Flags().Changed("include-notification") {
+		cfg.Notifications.Include = connectInclude
+	}
+	if cmd.Flags().Changed("exclude-notification") {
+		cfg.Notifications.Exclude = connectExclude
+	}
+	if cmd.Flags().Changed("shutdown-grace") {
+		cfg.ShutdownGrace = connectGrace
+	}
+	if cmd.Flags().Changed("timeout") {
+		cfg.Timeouts.Default = connectTimeout
+	}
+	// method・ツールごとのタイムアウトは設定ファイルの値にフラグの値を重ねる
+	if err := mergeTimeouts(&cfg.Timeouts.Methods, connectMethodTO); err != nil {
+		return fmt.Errorf("--method-timeout: %w", err)
+	}
+	if err := mergeTimeouts(&cfg.Timeouts.Tools, connectToolTO); err != nil {
+		return fmt.Errorf("--tool-timeout: %w", err)
+	}
 
 	if err := cfg.Validate(); err != nil {
 		return err
@@ -50,16 +98,58 @@ func runConnect(_ *cobra.Command, _ []string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	sigCh := make(chan os.Signal, 1)
+	// 1 回目のシグナルで終了処理（実行中のリクエストの完了待ち）を始め、2 回目で即座に終了する
+	var received os.Signal
+	sigCh := make(chan os.Signal, 2)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		<-sigCh
+		sig := <-sigCh
+		received = sig
 		cancel()
+		sig = <-sigCh
+		os.Exit(signalExitCode(sig))
 	}()
 
 	prx := proxy.New(cfg)
-	if err := prx.Run(ctx); err != nil && err != context.Canceled {
-		return fmt.Errorf("proxy: %w", err)
+	err = prx.Run(ctx)
+	// 終了理由を終了コードで伝えるため、cobra のエラー表示と使い方の表示は抑止する
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, proxy.ErrDrainTimeout):
+		return &exitError{code: exitDrainTimeout, err: err}
+	case errors.Is(err, context.Canceled):
+		// cancel を呼ぶのはシグナルを受けた goroutine だけで、received はその前に書き込まれている
+		return &exitError{code: signalExitCode(received)}
+	default:
+		return &exitError{code: 1, err: fmt.Errorf("proxy: %w", err)}
+	}
+}
+
+// mergeTimeouts はフラグで指定されたタイムアウトを解析して dst に追加します（同じ名前は上書き）。
+func mergeTimeouts(dst *map[string]time.Duration, flags map[string]string) error {
+	parsed, err := config.ParseTimeouts(flags)
+	if err != nil {
+		return err
+	}
+	if len(parsed) == 0 {
+		return nil
+	}
+	if *dst == nil {
+		*dst = make(map[string]time.Duration, len(parsed))
+	}
+	for name, d := range parsed {
+		(*dst)[name] = d
 	}
 	return nil
 }
+
+// signalExitCode はシグナルで終了したことを表す終了コード（128 + シグナル番号）を返します。
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
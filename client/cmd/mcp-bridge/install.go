@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
 	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/installer"
@@ -10,33 +14,192 @@ import (
 )
 
 var (
-	installURL     string
-	installProfile string
+	installURL        string
+	installProfile    string
+	installConfigPath string
+	installTarget     string
+	installRollback   bool
+	installDryRun     bool
+	installOutput     string
+)
+
+// install --output の値です。
+const (
+	outputText = "text"
+	outputJSON = "json"
 )
 
 var installCmd = &cobra.Command{
 	Use:   "install",
-	Short: "Install or update Claude Desktop config to use mcp-bridge",
-	Long:  "Updates claude_desktop_config.json to register this MCP server. Creates the config file and directory if they do not exist.",
+	Short: "Install or update MCP client config (Claude Desktop, Cursor, ...) to use mcp-bridge",
+	Long:  "Updates the MCP client's config (claude_desktop_config.json by default) to register this MCP server. Creates the config file and directory if they do not exist.",
 	RunE:  runInstall,
 }
 
 func init() {
 	installCmd.Flags().StringVar(&installURL, "url", config.DefaultSSEURL, "MCP server URL (e.g. http://localhost:8080/sse)")
-	installCmd.Flags().StringVar(&installProfile, "profile", "default", "AWS profile name to inject into Claude Desktop env")
+	installCmd.Flags().StringVar(&installProfile, "profile", "default", "Profile name for connect (passed to the MCP client entry as MCP_BRIDGE_PROFILE)")
+	installCmd.Flags().StringVar(&installConfigPath, "config", "", "Path to the MCP client config file (default: detected from the OS and the client installation)")
+	installCmd.Flags().StringVar(&installTarget, "target", "claude-desktop", targetUsage)
+	installCmd.Flags().BoolVar(&installRollback, "rollback", false, "Restore the config file from the most recent backup instead of installing")
+	installCmd.Flags().BoolVar(&installDryRun, "dry-run", false, "Print a unified diff of the changes without modifying the config file")
+	installCmd.Flags().StringVar(&installOutput, "output", outputText, "Output format: text or json (a machine-readable plan)")
+}
+
+// targetUsage は install / uninstall の --target の説明です。
+var targetUsage = func() string {
+	var names []string
+	for _, t := range installer.Targets() {
+		names = append(names, t.Name())
+	}
+	return fmt.Sprintf("MCP client to configure: %s, or %s for every detected client", strings.Join(names, ", "), installer.TargetAll)
+}()
+
+// resolveTargets は --target と --config から設定を書き換える MCP クライアントを決めます。
+func resolveTargets(name, configPath string) ([]installer.Target, error) {
+	if name != installer.TargetAll {
+		t, err := installer.TargetByName(name)
+		if err != nil {
+			return nil, err
+		}
+		return []installer.Target{t}, nil
+	}
+	if configPath != "" {
+		return nil, fmt.Errorf("--config は --target %s と同時に指定できません", installer.TargetAll)
+	}
+	found := installer.DetectedTargets()
+	if len(found) == 0 {
+		return nil, errors.New("インストールされている MCP クライアントが見つかりません。--target でクライアントを指定してください")
+	}
+	return found, nil
+}
+
+// displayNames は targets の表示名を読点で区切って返します。
+func displayNames(targets []installer.Target) string {
+	names := make([]string, len(targets))
+	for i, t := range targets {
+		names[i] = t.DisplayName()
+	}
+	return strings.Join(names, "、")
 }
 
 func runInstall(_ *cobra.Command, _ []string) error {
+	if installOutput != outputText && installOutput != outputJSON {
+		return fmt.Errorf("--output には %s または %s を指定してください: %s", outputText, outputJSON, installOutput)
+	}
+	if installRollback && (installDryRun || installOutput == outputJSON) {
+		return errors.New("--rollback は --dry-run や --output json と同時に指定できません")
+	}
+
 	binaryPath, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("実行バイナリのパス取得に失敗しました: %w", err)
 	}
 
-	svc := &installer.Service{}
-	if err := svc.Install(installURL, installProfile, binaryPath); err != nil {
+	targets, err := resolveTargets(installTarget, installConfigPath)
+	if err != nil {
 		return err
 	}
+	if installRollback {
+		return rollback(targets)
+	}
 
-	fmt.Println("設定を更新しました。Claude Desktop を再起動してください。")
-	return nil
+	// --target all では 1 つのクライアントで失敗しても残りのクライアントの設定を続ける
+	out := installOutputJSON{DryRun: installDryRun, Results: []installReport{}}
+	var updated []installer.Target
+	var errs []error
+	for _, t := range targets {
+		svc := &installer.Service{ConfigPath: installConfigPath, Target: t}
+		r := installReport{Target: t.Name()}
+		r.InstallPlan, err = svc.PlanInstall(installURL, installProfile, binaryPath)
+		if err == nil && !installDryRun {
+			err = svc.Apply(r.InstallPlan)
+			r.Applied = err == nil && r.Action != installer.ActionUnchanged
+		}
+		if err != nil {
+			r.Error = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", t.DisplayName(), err))
+		}
+		out.Results = append(out.Results, r)
+		if r.Applied {
+			updated = append(updated, t)
+		}
+
+		if installOutput == outputJSON || err != nil {
+			continue
+		}
+		switch {
+		case r.Action == installer.ActionUnchanged:
+			fmt.Printf("%s は既に同じ設定で登録されています: %s\n", t.DisplayName(), r.ConfigPath)
+		case installDryRun:
+			fmt.Printf("%s: %s を%sします。\n%s", t.DisplayName(), r.ConfigPath, actionLabel(r.Action), r.Diff)
+		default:
+			fmt.Printf("%s の設定を更新しました。\n", t.DisplayName())
+		}
+	}
+
+	switch {
+	case installOutput == outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	case installDryRun:
+		fmt.Println("--dry-run のため設定ファイルは変更していません。")
+	case len(updated) > 0:
+		fmt.Printf("%s を再起動してください。\n", displayNames(updated))
+	}
+	return errors.Join(errs...)
+}
+
+// installOutputJSON は install --output json の出力です。
+type installOutputJSON struct {
+	// DryRun は --dry-run で実行し、設定ファイルを変更していないかどうか
+	DryRun  bool            `json:"dryRun"`
+	Results []installReport `json:"results"`
+}
+
+// installReport はクライアントごとの install の計画と結果です。
+type installReport struct {
+	// Target は InstallPlan を作れなかった場合にもクライアントが分かるよう、InstallPlan の Target を上書きして出力する
+	Target string `json:"target"`
+	*installer.InstallPlan
+	// Applied は設定ファイルを書き換えたかどうか
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// actionLabel は --dry-run の表示に使う操作の名前です。
+func actionLabel(a installer.PlanAction) string {
+	if a == installer.ActionCreate {
+		return "作成"
+	}
+	return "更新"
+}
+
+// rollback は targets の設定ファイルを最も新しいバックアップに戻します。
+// --target all ではバックアップの無いクライアントは飛ばします。
+func rollback(targets []installer.Target) error {
+	var restored []installer.Target
+	var errs []error
+	for _, t := range targets {
+		svc := &installer.Service{ConfigPath: installConfigPath, Target: t}
+		res, err := svc.Rollback()
+		if errors.Is(err, installer.ErrNoBackup) && len(targets) > 1 {
+			fmt.Printf("%s: 戻せるバックアップがありません。\n", t.DisplayName())
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.DisplayName(), err))
+			continue
+		}
+		fmt.Printf("%s: %s を %s の内容に戻しました。\n", t.DisplayName(), res.ConfigPath, filepath.Base(res.Backup))
+		restored = append(restored, t)
+	}
+
+	if len(restored) > 0 {
+		fmt.Printf("%s を再起動してください。\n", displayNames(restored))
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/auth"
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// loginTimeout はブラウザでのログイン完了を待つ最大時間です。
+const loginTimeout = 5 * time.Minute
+
+var (
+	loginProfile string
+	loginDevice  bool
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in via the browser (OIDC authorization code + PKCE) and store tokens locally",
+	Long: "Opens the identity provider's login page in the browser, receives the authorization code on a loopback redirect, and stores the tokens for the profile.\n" +
+		"With --device, prints a verification URL and user code instead (OAuth 2.0 device authorization grant) for machines without a browser.",
+	RunE: runLogin,
+}
+
+func init() {
+	loginCmd.Flags().StringVar(&loginProfile, "profile", "", "Profile name (selects issuer/client_id and the token store entry)")
+	loginCmd.Flags().BoolVar(&loginDevice, "device", false, "Use the device authorization grant (for headless / SSH machines)")
+}
+
+func runLogin(_ *cobra.Command, _ []string) error {
+	cfg, err := config.LoadProfile(loginProfile)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	profile := cfg.ProfileName()
+	if !cfg.Auth.Enabled() {
+		return fmt.Errorf("プロファイル %q の issuer / client_id が設定されていません（.mcp-bridge.yaml を確認してください）", profile)
+	}
+
+	store, err := auth.StoreFor(cfg.Auth)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	client := auth.NewClient(cfg.Auth)
+	var tok *auth.Token
+	if loginDevice {
+		// デバイスコードの有効期限（expires_in）まで待つ
+		login := &auth.DeviceLogin{Client: client, Out: os.Stderr}
+		tok, err = login.Run(ctx)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+		defer cancel()
+		login := &auth.BrowserLogin{
+			Client:       client,
+			RedirectPort: cfg.Auth.RedirectPort,
+			Out:          os.Stderr,
+		}
+		tok, err = login.Run(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("ログインに失敗しました: %w", err)
+	}
+	if err := store.Save(profile, tok); err != nil {
+		return fmt.Errorf("トークンの保存に失敗しました: %w", err)
+	}
+
+	fmt.Printf("ログインしました（profile: %s）。\n", profile)
+	return nil
+}
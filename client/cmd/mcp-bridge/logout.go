@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/auth"
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var logoutProfile string
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Revoke the refresh token at the issuer and delete the stored tokens for the profile",
+	RunE:  runLogout,
+}
+
+func init() {
+	logoutCmd.Flags().StringVar(&logoutProfile, "profile", "", "Profile name")
+}
+
+func runLogout(_ *cobra.Command, _ []string) error {
+	cfg, err := config.LoadProfile(logoutProfile)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	profile := cfg.ProfileName()
+
+	store, err := auth.StoreFor(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	tok, err := store.Load(profile)
+	if errors.Is(err, auth.ErrNoToken) {
+		fmt.Printf("ログインしていません（profile: %s）。\n", profile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// 失効に失敗してもローカルのトークンは削除する（オフライン時やプロバイダ未対応時もログアウトできるように）
+	if tok.RefreshToken != "" && cfg.Auth.Enabled() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := auth.NewClient(cfg.Auth).Revoke(ctx, tok.RefreshToken); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: リフレッシュトークンの失効に失敗しました: %v\n", err)
+		}
+	}
+
+	if err := store.Delete(profile); err != nil && !errors.Is(err, auth.ErrNoToken) {
+		return fmt.Errorf("トークンの削除に失敗しました: %w", err)
+	}
+	fmt.Printf("ログアウトしました（profile: %s）。\n", profile)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/auth"
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var tokenProfile string
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Print a current access token for the profile, refreshing it if needed",
+	Long:  "Prints a valid access token to stdout, e.g. curl -H \"Authorization: Bearer $(mcp-bridge token)\" ...",
+	RunE:  runToken,
+}
+
+func init() {
+	tokenCmd.Flags().StringVar(&tokenProfile, "profile", "", "Profile name")
+}
+
+func runToken(_ *cobra.Command, _ []string) error {
+	cfg, err := config.LoadProfile(tokenProfile)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	src, err := auth.NewSource(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tok, err := src.Token(ctx)
+	if errors.Is(err, auth.ErrNoToken) {
+		return fmt.Errorf("ログインしていません（profile: %s）。`mcp-bridge login` を実行してください", cfg.ProfileName())
+	}
+	if err != nil {
+		return err
+	}
+	if !tok.Valid() {
+		return fmt.Errorf("アクセストークンの期限が切れており更新できません（profile: %s）。`mcp-bridge login` を実行してください", cfg.ProfileName())
+	}
+
+	fmt.Println(tok.AccessToken)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/installer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	uninstallConfigPath string
+	uninstallTarget     string
+	uninstallPurge      bool
+)
+
+var uninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Remove mcp-bridge from MCP client config (Claude Desktop, Cursor, ...)",
+	Long:  "Removes the vertex-ai-rag entry from the MCP client's config (claude_desktop_config.json by default), leaving other MCP servers and settings untouched. Succeeds without changes if the entry is not registered.",
+	RunE:  runUninstall,
+}
+
+func init() {
+	uninstallCmd.Flags().StringVar(&uninstallConfigPath, "config", "", "Path to the MCP client config file (default: detected from the OS and the client installation)")
+	uninstallCmd.Flags().StringVar(&uninstallTarget, "target", "claude-desktop", targetUsage)
+	uninstallCmd.Flags().BoolVar(&uninstallPurge, "purge", false, "Also delete the mcp-bridge config file in the home directory (~/.mcp-bridge.yaml)")
+}
+
+func runUninstall(_ *cobra.Command, _ []string) error {
+	targets, err := resolveTargets(uninstallTarget, uninstallConfigPath)
+	if err != nil {
+		return err
+	}
+
+	var removed []installer.Target
+	var errs []error
+	for _, t := range targets {
+		svc := &installer.Service{ConfigPath: uninstallConfigPath, Target: t}
+		res, err := svc.Uninstall()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.DisplayName(), err))
+			continue
+		}
+		switch {
+		case res.Removed:
+			fmt.Printf("%s: %s を削除しました: %s\n", t.DisplayName(), installer.ServerKey, res.ConfigPath)
+			removed = append(removed, t)
+		case res.ConfigPath == "":
+			fmt.Printf("%s: 設定ファイルが見つかりません。変更はありません。\n", t.DisplayName())
+		default:
+			fmt.Printf("%s: %s は登録されていません。変更はありません: %s\n", t.DisplayName(), installer.ServerKey, res.ConfigPath)
+		}
+	}
+
+	if uninstallPurge {
+		if err := purgeBridgeConfig(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(removed) > 0 {
+		fmt.Printf("%s を再起動してください。\n", displayNames(removed))
+	}
+	return errors.Join(errs...)
+}
+
+// purgeBridgeConfig は $HOME の mcp-bridge の設定ファイル（~/.mcp-bridge.yaml）を削除します。
+// カレントディレクトリの .mcp-bridge.yaml はプロジェクトのファイルかもしれないので削除しません。
+func purgeBridgeConfig() error {
+	path := config.HomeFile()
+	if used := config.FileUsed(); used != "" && used != path {
+		fmt.Printf("カレントディレクトリの設定ファイルは削除しません: %s\n", used)
+	}
+	if path == "" {
+		fmt.Println("削除する設定ファイル (~/.mcp-bridge.yaml) はありません。")
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("設定ファイルの削除に失敗しました (%s): %w", path, err)
+	}
+	fmt.Printf("設定ファイルを削除しました: %s\n", path)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/auth"
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var whoamiProfile string
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the subject, groups and expiry of the stored ID token for the profile",
+	RunE:  runWhoami,
+}
+
+func init() {
+	whoamiCmd.Flags().StringVar(&whoamiProfile, "profile", "", "Profile name")
+}
+
+func runWhoami(_ *cobra.Command, _ []string) error {
+	cfg, err := config.LoadProfile(whoamiProfile)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	profile := cfg.ProfileName()
+
+	store, err := auth.StoreFor(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	tok, err := store.Load(profile)
+	if errors.Is(err, auth.ErrNoToken) {
+		return fmt.Errorf("ログインしていません（profile: %s）。`mcp-bridge login` を実行してください", profile)
+	}
+	if err != nil {
+		return err
+	}
+	if tok.IDToken == "" {
+		return fmt.Errorf("保存されたトークンに ID トークンが含まれていません（profile: %s）", profile)
+	}
+	claims, err := auth.ParseIDToken(tok.IDToken)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("profile:  %s\n", profile)
+	fmt.Printf("issuer:   %s\n", claims.Issuer)
+	fmt.Printf("subject:  %s\n", claims.Subject)
+	if claims.Username != "" {
+		fmt.Printf("username: %s\n", claims.Username)
+	}
+	if claims.Email != "" {
+		fmt.Printf("email:    %s\n", claims.Email)
+	}
+	fmt.Printf("groups:   %s\n", strings.Join(claims.Groups, ", "))
+	fmt.Printf("expires:  %s\n", formatExpiry(claims.Expiry))
+	fmt.Printf("access token expires: %s\n", formatExpiry(tok.Expiry))
+	return nil
+}
+
+// formatExpiry は期限をローカル時刻と残り時間（期限切れの場合はその旨）で表します。
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	d := time.Until(t).Round(time.Second)
+	if d <= 0 {
+		return fmt.Sprintf("%s (expired)", t.Local().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s (in %s)", t.Local().Format(time.RFC3339), d)
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Claims は ID トークンから表示用に取り出すクレームです。
+type Claims struct {
+	Issuer   string
+	Subject  string
+	Email    string
+	Username string
+	Groups   []string
+	Expiry   time.Time
+}
+
+// ParseIDToken は ID トークン（JWT）のペイロードをデコードします。
+// 署名は検証しません。ローカルに保存した自分のトークンの内容を表示する用途に限って使ってください。
+func ParseIDToken(raw string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode id token payload: %w", err)
+	}
+
+	var m struct {
+		Iss           string   `json:"iss"`
+		Sub           string   `json:"sub"`
+		Email         string   `json:"email"`
+		Username      string   `json:"cognito:username"`
+		PreferredName string   `json:"preferred_username"`
+		CognitoGroups []string `json:"cognito:groups"`
+		Groups        []string `json:"groups"`
+		Exp           int64    `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse id token payload: %w", err)
+	}
+
+	c := &Claims{
+		Issuer:   m.Iss,
+		Subject:  m.Sub,
+		Email:    m.Email,
+		Username: m.Username,
+		Groups:   m.CognitoGroups,
+	}
+	if c.Username == "" {
+		c.Username = m.PreferredName
+	}
+	if len(c.Groups) == 0 {
+		c.Groups = m.Groups
+	}
+	if m.Exp > 0 {
+		c.Expiry = time.Unix(m.Exp, 0)
+	}
+	return c, nil
+}
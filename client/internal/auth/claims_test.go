@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakeJWT(payload string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc([]byte(`{"alg":"RS256"}`)) + "." + enc([]byte(payload)) + ".sig"
+}
+
+func TestParseIDToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		raw        string
+		wantSub    string
+		wantUser   string
+		wantGroups []string
+		wantExp    time.Time
+		wantErr    bool
+	}{
+		{
+			name:       "cognito claims",
+			raw:        fakeJWT(`{"sub":"u-1","cognito:username":"alice","cognito:groups":["hr","eng"],"exp":1700000000}`),
+			wantSub:    "u-1",
+			wantUser:   "alice",
+			wantGroups: []string{"hr", "eng"},
+			wantExp:    time.Unix(1700000000, 0),
+		},
+		{
+			name:       "generic oidc claims",
+			raw:        fakeJWT(`{"sub":"u-2","preferred_username":"bob","groups":["ops"]}`),
+			wantSub:    "u-2",
+			wantUser:   "bob",
+			wantGroups: []string{"ops"},
+		},
+		{name: "not a jwt", raw: "opaque-token", wantErr: true},
+		{name: "bad payload", raw: "a.!!!.c", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseIDToken(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseIDToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Subject != tt.wantSub || got.Username != tt.wantUser {
+				t.Errorf("Subject/Username = %q/%q, want %q/%q", got.Subject, got.Username, tt.wantSub, tt.wantUser)
+			}
+			if strings.Join(got.Groups, ",") != strings.Join(tt.wantGroups, ",") {
+				t.Errorf("Groups = %v, want %v", got.Groups, tt.wantGroups)
+			}
+			if !got.Expiry.Equal(tt.wantExp) {
+				t.Errorf("Expiry = %v, want %v", got.Expiry, tt.wantExp)
+			}
+		})
+	}
+}
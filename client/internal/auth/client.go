@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+)
+
+// ProviderMetadata は OIDC Discovery（.well-known/openid-configuration）で取得するエンドポイント情報です。
+type ProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	// DeviceAuthorizationEndpoint はデバイス認可グラント（RFC 8628）のエンドポイント。未対応のプロバイダでは空。
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	// RevocationEndpoint はトークン失効（RFC 7009）のエンドポイント。
+	RevocationEndpoint string `json:"revocation_endpoint"`
+}
+
+const (
+	cognitoAuthorizePath = "/oauth2/authorize"
+	cognitoRevokePath    = "/oauth2/revoke"
+)
+
+// ErrRevocationUnsupported はプロバイダがトークン失効エンドポイントを提供していないことを表します。
+var ErrRevocationUnsupported = errors.New("issuer does not provide a revocation endpoint")
+
+// Client は OIDC プロバイダのエンドポイントを呼び出します。
+type Client struct {
+	// HTTPClient は通信に使う HTTP クライアント。nil の場合は http.DefaultClient を使う。
+	HTTPClient *http.Client
+	// Issuer は OIDC Issuer URL
+	Issuer string
+	// ClientID は OAuth2 クライアント ID
+	ClientID string
+	// Scopes は要求するスコープ
+	Scopes []string
+
+	mu   sync.Mutex
+	meta *ProviderMetadata
+}
+
+// NewClient は認証設定から Client を生成します。
+func NewClient(cfg config.AuthConfig) *Client {
+	return &Client{
+		Issuer:   cfg.Issuer,
+		ClientID: cfg.ClientID,
+		Scopes:   cfg.Scopes,
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Discover は OIDC Discovery ドキュメントを取得します。結果はキャッシュされます。
+func (c *Client) Discover(ctx context.Context) (*ProviderMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.meta != nil {
+		return c.meta, nil
+	}
+
+	wellKnown := strings.TrimSuffix(c.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build discovery request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("discovery document: status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var meta ProviderMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery document: %w", err)
+	}
+	if meta.TokenEndpoint == "" {
+		return nil, fmt.Errorf("discovery document has no token_endpoint")
+	}
+	if meta.RevocationEndpoint == "" && strings.HasSuffix(meta.AuthorizationEndpoint, cognitoAuthorizePath) {
+		// Cognito は Discovery に revocation_endpoint を載せないが、/oauth2/revoke を提供している
+		meta.RevocationEndpoint = strings.TrimSuffix(meta.AuthorizationEndpoint, cognitoAuthorizePath) + cognitoRevokePath
+	}
+	c.meta = &meta
+	return c.meta, nil
+}
+
+// ExchangeCode は認可コードをトークンに交換します（PKCE の code_verifier を添えます）。
+func (c *Client) ExchangeCode(ctx context.Context, code, redirectURI, verifier string) (*Token, error) {
+	return c.tokenRequest(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+// tokenRequest はトークンエンドポイントへ form を POST し、レスポンスを Token に変換します。
+func (c *Client) tokenRequest(ctx context.Context, form url.Values) (*Token, error) {
+	meta, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form.Set("client_id", c.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseOAuthError(resp.StatusCode, body)
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+	return tr.token(), nil
+}
+
+// OAuthError はトークンエンドポイントなどが返す OAuth2 エラーレスポンス（RFC 6749 5.2）です。
+type OAuthError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth error: %s: %s (status %d)", e.Code, e.Description, e.StatusCode)
+	}
+	return fmt.Sprintf("oauth error: %s (status %d)", e.Code, e.StatusCode)
+}
+
+func parseOAuthError(status int, body []byte) error {
+	oe := &OAuthError{StatusCode: status}
+	if err := json.Unmarshal(body, oe); err != nil || oe.Code == "" {
+		return fmt.Errorf("oauth endpoint: status %d: %s", status, string(body))
+	}
+	return oe
+}
+
+// Refresh はリフレッシュトークンで新しいトークンを取得します。
+// レスポンスに refresh_token が含まれない場合（Cognito など）は元のリフレッシュトークンを引き継ぎます。
+func (c *Client) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	tok, err := c.tokenRequest(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refreshToken
+	}
+	return tok, nil
+}
+
+// Revoke はリフレッシュトークンをプロバイダ側で失効させます（RFC 7009）。
+// プロバイダが失効エンドポイントを提供していない場合は ErrRevocationUnsupported を返します。
+func (c *Client) Revoke(ctx context.Context, refreshToken string) error {
+	meta, err := c.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	if meta.RevocationEndpoint == "" {
+		return ErrRevocationUnsupported
+	}
+
+	form := url.Values{
+		"token":           {refreshToken},
+		"token_type_hint": {"refresh_token"},
+		"client_id":       {c.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.RevocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to build revocation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call revocation endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return parseOAuthError(resp.StatusCode, body)
+	}
+	return nil
+}
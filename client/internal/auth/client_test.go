@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_Revoke(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	if err := issuer.client().Revoke(context.Background(), "refresh-1"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if len(issuer.revoked) != 1 || issuer.revoked[0] != "refresh-1" {
+		t.Errorf("revoked = %v, want [refresh-1]", issuer.revoked)
+	}
+
+	bad := issuer.client()
+	bad.ClientID = "other"
+	var oe *OAuthError
+	if err := bad.Revoke(context.Background(), "refresh-1"); !errors.As(err, &oe) || oe.Code != "invalid_client" {
+		t.Errorf("Revoke() with wrong client error = %v, want invalid_client", err)
+	}
+}
+
+func TestClient_Discover_revocationEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		want     string
+	}{
+		{
+			name:     "explicit endpoint",
+			metadata: `{"authorization_endpoint":"https://idp/authorize","token_endpoint":"https://idp/token","revocation_endpoint":"https://idp/revoke"}`,
+			want:     "https://idp/revoke",
+		},
+		{
+			name:     "cognito derived from hosted UI domain",
+			metadata: `{"authorization_endpoint":"https://pool.auth.ap-northeast-1.amazoncognito.com/oauth2/authorize","token_endpoint":"https://pool.auth.ap-northeast-1.amazoncognito.com/oauth2/token"}`,
+			want:     "https://pool.auth.ap-northeast-1.amazoncognito.com/oauth2/revoke",
+		},
+		{
+			name:     "unsupported",
+			metadata: `{"authorization_endpoint":"https://idp/authorize","token_endpoint":"https://idp/token"}`,
+			want:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(tt.metadata))
+			}))
+			defer srv.Close()
+
+			c := &Client{HTTPClient: srv.Client(), Issuer: srv.URL}
+			meta, err := c.Discover(context.Background())
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+			if meta.RevocationEndpoint != tt.want {
+				t.Errorf("RevocationEndpoint = %q, want %q", meta.RevocationEndpoint, tt.want)
+			}
+			if tt.want == "" {
+				if err := c.Revoke(context.Background(), "x"); !errors.Is(err, ErrRevocationUnsupported) {
+					t.Errorf("Revoke() error = %v, want ErrRevocationUnsupported", err)
+				}
+			}
+		})
+	}
+}
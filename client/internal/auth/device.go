@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// deviceCodeGrantType はデバイス認可グラントでトークンを取得する際の grant_type です（RFC 8628 3.4）。
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// pollUnit はサーバーが返す interval（秒）の単位です。テストでは短くします。
+var pollUnit = time.Second
+
+// defaultPollInterval はサーバーが interval を返さない場合のポーリング間隔です（RFC 8628 3.2）。
+const defaultPollInterval = 5
+
+// deviceAuthResponse はデバイス認可エンドポイントのレスポンスです（RFC 8628 3.2）。
+type deviceAuthResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceLogin はデバイス認可グラント（RFC 8628）でログインします。
+// ブラウザのない環境（SSH 先の開発マシンなど）向けに、検証 URL とユーザーコードを表示してトークンエンドポイントをポーリングします。
+type DeviceLogin struct {
+	Client *Client
+	// Out は検証 URL とユーザーコードの表示先
+	Out io.Writer
+}
+
+// Run はログインフローを実行し、ユーザーが別端末で承認したらトークンを返します。
+func (l *DeviceLogin) Run(ctx context.Context) (*Token, error) {
+	meta, err := l.Client.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if meta.DeviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("issuer %s does not support the device authorization grant", l.Client.Issuer)
+	}
+
+	da, err := l.authorize(ctx, meta.DeviceAuthorizationEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Out != nil {
+		fmt.Fprintf(l.Out, "別の端末のブラウザで次の URL を開き、コードを入力してください:\n  URL:  %s\n  コード: %s\n", da.VerificationURI, da.UserCode)
+		if da.VerificationURIComplete != "" {
+			fmt.Fprintf(l.Out, "（コード入力済みの URL: %s）\n", da.VerificationURIComplete)
+		}
+	}
+
+	if da.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(da.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	interval := da.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	form := url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {da.DeviceCode},
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, errors.New("device code expired before the login was approved")
+			}
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(interval) * pollUnit):
+		}
+
+		tok, err := l.Client.tokenRequest(ctx, form)
+		if err == nil {
+			return tok, nil
+		}
+		if ctx.Err() != nil {
+			// ポーリング中に期限切れになった場合も、通信エラーではなく期限切れとして報告する
+			continue
+		}
+		var oe *OAuthError
+		if !errors.As(err, &oe) {
+			return nil, err
+		}
+		switch oe.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5
+		default:
+			// access_denied / expired_token など
+			return nil, err
+		}
+	}
+}
+
+// authorize はデバイス認可エンドポイントを呼び出し、デバイスコードとユーザーコードを取得します。
+func (l *DeviceLogin) authorize(ctx context.Context, endpoint string) (*deviceAuthResponse, error) {
+	form := url.Values{
+		"client_id": {l.Client.ClientID},
+		"scope":     {strings.Join(l.Client.Scopes, " ")},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build device authorization request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := l.Client.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call device authorization endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device authorization response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseOAuthError(resp.StatusCode, body)
+	}
+
+	var da deviceAuthResponse
+	if err := json.Unmarshal(body, &da); err != nil {
+		return nil, fmt.Errorf("failed to parse device authorization response: %w", err)
+	}
+	if da.DeviceCode == "" || da.UserCode == "" || da.VerificationURI == "" {
+		return nil, errors.New("device authorization response is missing device_code, user_code or verification_uri")
+	}
+	return &da, nil
+}
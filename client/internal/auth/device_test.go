@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeviceLogin_Run(t *testing.T) {
+	orig := pollUnit
+	pollUnit = 10 * time.Millisecond
+	t.Cleanup(func() { pollUnit = orig })
+
+	tests := []struct {
+		name      string
+		polls     []string
+		expiresIn int
+		wantErr   string
+		wantPolls int
+	}{
+		{"approved after pending", []string{"authorization_pending", "authorization_pending", ""}, 60, "", 3},
+		{"slow down then approved", []string{"slow_down", ""}, 60, "", 2},
+		{"access denied", []string{"authorization_pending", "access_denied"}, 60, "access_denied", 2},
+		{"device code expired", []string{"authorization_pending"}, 1, "expired", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			issuer.devicePolls = tt.polls
+			issuer.deviceExpiresIn = tt.expiresIn
+
+			var out bytes.Buffer
+			login := &DeviceLogin{Client: issuer.client(), Out: &out}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			tok, err := login.Run(ctx)
+
+			if !strings.Contains(out.String(), "ABCD-EFGH") || !strings.Contains(out.String(), "/activate") {
+				t.Errorf("output does not show verification URL and user code: %q", out.String())
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if tok.AccessToken != "device-access" {
+				t.Errorf("AccessToken = %q, want device-access", tok.AccessToken)
+			}
+			if got := len(issuer.tokenRequests); got != tt.wantPolls {
+				t.Errorf("token polls = %d, want %d", got, tt.wantPolls)
+			}
+		})
+	}
+}
+
+func TestDeviceLogin_unsupported(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	client := issuer.client()
+	meta, err := client.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.DeviceAuthorizationEndpoint = ""
+
+	_, err = (&DeviceLogin{Client: client}).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "device authorization grant") {
+		t.Fatalf("Run() error = %v, want unsupported error", err)
+	}
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// fakeIssuer は httptest 上で動く最小限の OIDC プロバイダです。
+type fakeIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu sync.Mutex
+	// codes は発行済み認可コード -> code_challenge
+	codes map[string]string
+	// tokenRequests はトークンエンドポイントに届いた form
+	tokenRequests []url.Values
+	// refreshes は refresh_token グラントの処理回数
+	refreshes int
+	// devicePolls はデバイスコードグラントの応答列。先頭から順に返し、尽きたら最後の要素を返し続ける。
+	// "" はトークン発行、それ以外は OAuth2 エラーコード。
+	devicePolls []string
+	// deviceExpiresIn はデバイス認可レスポンスの expires_in
+	deviceExpiresIn int
+	// revoked は失効させたトークン
+	revoked []string
+	// tokenHandler が設定されている場合、トークンエンドポイントの処理を差し替える
+	tokenHandler func(w http.ResponseWriter, form url.Values)
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	f := &fakeIssuer{t: t, codes: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                        f.srv.URL,
+			"authorization_endpoint":        f.srv.URL + "/authorize",
+			"token_endpoint":                f.srv.URL + "/token",
+			"device_authorization_endpoint": f.srv.URL + "/device",
+			"revocation_endpoint":           f.srv.URL + "/revoke",
+		})
+	})
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	mux.HandleFunc("/device", f.device)
+	mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "test-client" {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid_client"})
+			return
+		}
+		f.mu.Lock()
+		f.revoked = append(f.revoked, r.FormValue("token"))
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeIssuer) client() *Client {
+	return &Client{
+		HTTPClient: f.srv.Client(),
+		Issuer:     f.srv.URL,
+		ClientID:   "test-client",
+		Scopes:     []string{"openid"},
+	}
+}
+
+// authorize はユーザーがログインに成功したものとして、redirect_uri にコードと state を付けてリダイレクトします。
+func (f *fakeIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "S256 required", http.StatusBadRequest)
+		return
+	}
+	code := "code-" + q.Get("state")
+	f.mu.Lock()
+	f.codes[code] = q.Get("code_challenge")
+	f.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.tokenRequests = append(f.tokenRequests, r.PostForm)
+	handler := f.tokenHandler
+	f.mu.Unlock()
+	if handler != nil {
+		handler(w, r.PostForm)
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		f.mu.Lock()
+		challenge, ok := f.codes[r.PostForm.Get("code")]
+		delete(f.codes, r.PostForm.Get("code"))
+		f.mu.Unlock()
+		if !ok || codeChallenge(r.PostForm.Get("code_verifier")) != challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token":  "access-1",
+			"refresh_token": "refresh-1",
+			"id_token":      "id-1",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	case deviceCodeGrantType:
+		if r.PostForm.Get("device_code") != "device-code" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+			return
+		}
+		f.mu.Lock()
+		result := f.devicePolls[0]
+		if len(f.devicePolls) > 1 {
+			f.devicePolls = f.devicePolls[1:]
+		}
+		f.mu.Unlock()
+		if result != "" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": result})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token":  "device-access",
+			"refresh_token": "refresh-1",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") != "refresh-1" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+			return
+		}
+		f.mu.Lock()
+		f.refreshes++
+		n := f.refreshes
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token": fmt.Sprintf("refreshed-%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "unsupported_grant_type"})
+	}
+}
+
+func (f *fakeIssuer) device(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != "test-client" {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid_client"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"device_code":      "device-code",
+		"user_code":        "ABCD-EFGH",
+		"verification_uri": f.srv.URL + "/activate",
+		"expires_in":       f.deviceExpiresIn,
+		"interval":         1,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
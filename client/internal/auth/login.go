@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// callbackPath はループバックリダイレクトのパスです。
+const callbackPath = "/callback"
+
+// BrowserLogin は認可コード + PKCE フローでブラウザログインを行います。
+// localhost でリダイレクトを待ち受け、受け取った認可コードをトークンに交換します。
+type BrowserLogin struct {
+	Client *Client
+	// RedirectPort は待ち受けポート。0 の場合は空きポートを使う。
+	// Cognito などリダイレクト URI の完全一致が必要なプロバイダでは固定ポートを登録しておく。
+	RedirectPort int
+	// OpenBrowser は認可 URL をブラウザで開く関数。nil の場合は OS 既定のブラウザを起動する。
+	OpenBrowser func(authURL string) error
+	// Out はブラウザが開けなかった場合などに認可 URL を表示する出力先。nil の場合は出力しない。
+	Out io.Writer
+}
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// Run はログインフローを実行し、取得したトークンを返します。ctx がキャンセルされるまでリダイレクトを待ちます。
+func (l *BrowserLogin) Run(ctx context.Context) (*Token, error) {
+	meta, err := l.Client.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if meta.AuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("discovery document has no authorization_endpoint")
+	}
+
+	lns, err := listenLoopback(l.RedirectPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for redirect: %w", err)
+	}
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
+	// Cognito は http のコールバック URL を localhost にしか許可しないので、IP リテラルではなく localhost を使う
+	redirectURI := fmt.Sprintf("http://localhost:%d%s", lns[0].Addr().(*net.TCPAddr).Port, callbackPath)
+
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization_endpoint: %w", err)
+	}
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", l.Client.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(l.Client.Scopes, " "))
+	q.Set("state", state)
+	q.Set("code_challenge", codeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	authURL.RawQuery = q.Encode()
+
+	resultCh := make(chan callbackResult, 1)
+	srv := &http.Server{Handler: callbackHandler(state, resultCh)}
+	for _, ln := range lns {
+		go func() { _ = srv.Serve(ln) }()
+	}
+	defer srv.Close()
+
+	l.printf("ブラウザでログインしてください:\n%s\n", authURL.String())
+	open := l.OpenBrowser
+	if open == nil {
+		open = openBrowser
+	}
+	if err := open(authURL.String()); err != nil {
+		l.printf("ブラウザを起動できませんでした（%v）。上記 URL を手動で開いてください。\n", err)
+	}
+
+	var res callbackResult
+	select {
+	case res = <-resultCh:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	return l.Client.ExchangeCode(ctx, res.code, redirectURI, verifier)
+}
+
+// listenLoopback は 127.0.0.1 と ::1 の同じポートで待ち受けます。
+// localhost がどちらに解決されてもリダイレクトを受け取れるようにするためです。
+// IPv6 が無効な環境では ::1 の待ち受けに失敗するので、その場合は 127.0.0.1 だけを返します。
+func listenLoopback(port int) ([]net.Listener, error) {
+	ln4, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	lns := []net.Listener{ln4}
+	if ln6, err := net.Listen("tcp", fmt.Sprintf("[::1]:%d", ln4.Addr().(*net.TCPAddr).Port)); err == nil {
+		lns = append(lns, ln6)
+	}
+	return lns, nil
+}
+
+func (l *BrowserLogin) printf(format string, args ...any) {
+	if l.Out != nil {
+		fmt.Fprintf(l.Out, format, args...)
+	}
+}
+
+// callbackHandler はリダイレクトを受け取り、state を検証して認可コードを resultCh に送ります。
+// 最初の 1 回のみ結果を送り、それ以降のリクエストは無視します。
+func callbackHandler(state string, resultCh chan<- callbackResult) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var res callbackResult
+		switch {
+		case q.Get("error") != "":
+			res.err = &OAuthError{Code: q.Get("error"), Description: q.Get("error_description")}
+		case q.Get("state") != state:
+			res.err = errors.New("state mismatch in authorization response")
+		case q.Get("code") == "":
+			res.err = errors.New("authorization response has no code")
+		default:
+			res.code = q.Get("code")
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if res.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<html><body><p>ログインに失敗しました。ターミナルを確認してください。</p></body></html>")
+		} else {
+			fmt.Fprint(w, "<html><body><p>ログインしました。このウィンドウを閉じてください。</p></body></html>")
+		}
+
+		select {
+		case resultCh <- res:
+		default:
+		}
+	})
+	return mux
+}
+
+// codeChallenge は PKCE の S256 code_challenge を返します（RFC 7636 4.2）。
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomString は n バイトの乱数を base64url でエンコードした文字列を返します。
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// openBrowser は OS 既定のブラウザで u を開きます。
+func openBrowser(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	return cmd.Start()
+}
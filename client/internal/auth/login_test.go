@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBrowserLogin_Run(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	client := issuer.client()
+
+	tests := []struct {
+		name string
+		// browse はブラウザの代わりに認可 URL を処理する
+		browse    func(authURL string) error
+		wantErr   string
+		wantToken string
+	}{
+		{
+			name: "success",
+			browse: func(authURL string) error {
+				go func() {
+					resp, err := issuer.srv.Client().Get(authURL)
+					if err == nil {
+						resp.Body.Close()
+					}
+				}()
+				return nil
+			},
+			wantToken: "access-1",
+		},
+		{
+			name: "state mismatch",
+			browse: func(authURL string) error {
+				go func() {
+					redirect := redirectURIFrom(t, authURL) + "?code=x&state=wrong"
+					resp, err := http.Get(redirect)
+					if err == nil {
+						resp.Body.Close()
+					}
+				}()
+				return nil
+			},
+			wantErr: "state mismatch",
+		},
+		{
+			name: "access denied",
+			browse: func(authURL string) error {
+				go func() {
+					resp, err := http.Get(redirectURIFrom(t, authURL) + "?error=access_denied")
+					if err == nil {
+						resp.Body.Close()
+					}
+				}()
+				return nil
+			},
+			wantErr: "access_denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			login := &BrowserLogin{Client: client, OpenBrowser: tt.browse}
+			tok, err := login.Run(ctx)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if tok.AccessToken != tt.wantToken {
+				t.Errorf("AccessToken = %q, want %q", tok.AccessToken, tt.wantToken)
+			}
+			if tok.RefreshToken == "" || tok.Expiry.IsZero() {
+				t.Errorf("token missing refresh_token or expiry: %+v", tok)
+			}
+		})
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 Appendix B の例
+	got := codeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got != want {
+		t.Errorf("codeChallenge() = %q, want %q", got, want)
+	}
+}
+
+// redirectURIFrom は認可 URL の redirect_uri を返します。redirect_uri は http://localhost でなければなりません。
+func redirectURIFrom(t *testing.T, authURL string) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, authURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := req.URL.Query().Get("redirect_uri")
+	if !strings.HasPrefix(uri, "http://localhost:") || !strings.HasSuffix(uri, callbackPath) {
+		t.Fatalf("redirect_uri = %q, want http://localhost:<port>%s", uri, callbackPath)
+	}
+	return uri
+}
+
+func TestListenLoopback(t *testing.T) {
+	lns, err := listenLoopback(0)
+	if err != nil {
+		t.Fatalf("listenLoopback() error = %v", err)
+	}
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
+	if len(lns) < 2 {
+		t.Skip("IPv6 loopback is not available")
+	}
+	port4 := lns[0].Addr().(*net.TCPAddr).Port
+	port6 := lns[1].Addr().(*net.TCPAddr).Port
+	if port4 != port6 {
+		t.Errorf("ports = %d and %d, want the same port on 127.0.0.1 and ::1", port4, port6)
+	}
+	if ip := lns[1].Addr().(*net.TCPAddr).IP; !ip.Equal(net.IPv6loopback) {
+		t.Errorf("second listener IP = %v, want ::1", ip)
+	}
+}
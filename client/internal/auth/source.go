@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+)
+
+// DefaultRefreshSkew はアクセストークンの期限切れより前に更新を始める猶予です。
+const DefaultRefreshSkew = time.Minute
+
+// ErrNoRefreshToken はリフレッシュトークンがなく更新できないことを表します。
+var ErrNoRefreshToken = errors.New("no refresh token; run `mcp-bridge login`")
+
+// TokenStore はプロファイルごとのトークンの読み書き先です。
+type TokenStore interface {
+	Load(profile string) (*Token, error)
+	Save(profile string, tok *Token) error
+	Delete(profile string) error
+}
+
+// Source はプロファイルの有効なアクセストークンを提供し、必要に応じてリフレッシュします。
+// 複数の goroutine から同時にリフレッシュが要求された場合もトークンエンドポイントへの呼び出しは 1 回にまとめます。
+type Source struct {
+	// Client はリフレッシュに使う OIDC クライアント。nil の場合はリフレッシュしない。
+	Client *Client
+	Store  TokenStore
+	// Profile はトークンを読み書きするプロファイル名
+	Profile string
+	// Skew は期限切れ前に更新を始める猶予。0 の場合は DefaultRefreshSkew を使う。
+	Skew time.Duration
+
+	mu       sync.Mutex
+	tok      *Token
+	inflight *refreshCall
+}
+
+// refreshCall は実行中のリフレッシュです。完了すると done が閉じられます。
+type refreshCall struct {
+	done chan struct{}
+	tok  *Token
+	err  error
+}
+
+func (s *Source) skew() time.Duration {
+	if s.Skew > 0 {
+		return s.Skew
+	}
+	return DefaultRefreshSkew
+}
+
+// Token は有効なアクセストークンを返します。期限切れが近い場合は先にリフレッシュします。
+// トークンが保存されていない場合は ErrNoToken を返します。
+func (s *Source) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	if s.tok == nil {
+		tok, err := s.Store.Load(s.Profile)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.tok = tok
+	}
+	tok := s.tok
+	s.mu.Unlock()
+
+	if tok.ExpiresWithin(s.skew()) && tok.RefreshToken != "" && s.Client != nil {
+		refreshed, err := s.Refresh(ctx, tok)
+		if err != nil {
+			if tok.Valid() {
+				// まだ期限内なら今のトークンで続行し、次回また更新を試みる
+				return tok, nil
+			}
+			return nil, err
+		}
+		return refreshed, nil
+	}
+	return tok, nil
+}
+
+// Refresh はトークンを強制的にリフレッシュします（サーバーが 401 を返した場合など）。
+// stale は呼び出し側が使って失敗したトークンで、既に別の goroutine が更新済みであれば
+// トークンエンドポイントを呼ばずに更新後のトークンを返します。
+// 別のプロセス（他の MCP クライアントが起動したブリッジや mcp-bridge login）が Store に新しいトークンを保存していれば、それを使います。
+func (s *Source) Refresh(ctx context.Context, stale *Token) (*Token, error) {
+	s.mu.Lock()
+	if s.tok != nil && stale != nil && s.tok.AccessToken != stale.AccessToken {
+		tok := s.tok
+		s.mu.Unlock()
+		return tok, nil
+	}
+	if call := s.inflight; call != nil {
+		s.mu.Unlock()
+		return call.wait(ctx)
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	s.inflight = call
+	current := s.tok
+	s.mu.Unlock()
+
+	call.tok, call.err = s.refresh(current)
+
+	s.mu.Lock()
+	if call.err == nil {
+		s.tok = call.tok
+	}
+	s.inflight = nil
+	s.mu.Unlock()
+	close(call.done)
+	return call.wait(ctx)
+}
+
+// refresh はトークンエンドポイントを呼び出して結果を保存します。
+// 先に Store を読み直し、current と異なる有効なトークンが保存されていればトークンエンドポイントを呼ばずにそれを返します。
+// 呼び出し元の ctx がキャンセルされても他の待機者のために完了させるので、独立したタイムアウトで実行します。
+func (s *Source) refresh(current *Token) (*Token, error) {
+	if stored := s.reload(current); stored != nil {
+		if !stored.ExpiresWithin(s.skew()) {
+			return stored, nil
+		}
+		// 期限が近くても、保存されているリフレッシュトークンの方が新しい
+		current = stored
+	}
+	if current == nil || current.RefreshToken == "" || s.Client == nil {
+		return nil, ErrNoRefreshToken
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tok, err := s.Client.Refresh(ctx, current.RefreshToken)
+	if err != nil {
+		// 別のプロセスが同時にリフレッシュしてリフレッシュトークンが無効になった場合は、そのプロセスが保存したトークンを使う
+		if stored := s.reload(current); stored != nil && stored.Valid() {
+			return stored, nil
+		}
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+	if tok.IDToken == "" {
+		tok.IDToken = current.IDToken
+	}
+	if err := s.Store.Save(s.Profile, tok); err != nil {
+		return nil, fmt.Errorf("failed to save refreshed token: %w", err)
+	}
+	return tok, nil
+}
+
+// reload は Store からトークンを読み直し、used と異なるトークンが保存されていればそれを返します。
+// 読み込めない場合や used と同じ場合は nil を返します。
+func (s *Source) reload(used *Token) *Token {
+	stored, err := s.Store.Load(s.Profile)
+	if err != nil {
+		return nil
+	}
+	if used != nil && stored.AccessToken == used.AccessToken {
+		return nil
+	}
+	return stored
+}
+
+func (c *refreshCall) wait(ctx context.Context) (*Token, error) {
+	select {
+	case <-c.done:
+		return c.tok, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// StoreFor は認証設定に応じた TokenStore を返します。token_file が設定されていればそのファイル、
+// そうでなければ DefaultStore を使います。
+func StoreFor(cfg config.AuthConfig) (TokenStore, error) {
+	if cfg.TokenFile != "" {
+		return &FileStore{Path: cfg.TokenFile}, nil
+	}
+	return DefaultStore()
+}
+
+// NewSource は設定のプロファイルに対応する Source を生成します。
+// issuer / client_id が未設定の場合はリフレッシュせず、保存済みのトークンをそのまま使います。
+func NewSource(cfg *config.Config) (*Source, error) {
+	store, err := StoreFor(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	src := &Source{Store: store, Profile: cfg.ProfileName()}
+	if cfg.Auth.Enabled() {
+		src.Client = NewClient(cfg.Auth)
+	}
+	return src, nil
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore はテスト用のメモリ上の TokenStore です。
+type memStore struct {
+	mu     sync.Mutex
+	tokens map[string]*Token
+	saves  int
+}
+
+func newMemStore(profile string, tok *Token) *memStore {
+	s := &memStore{tokens: map[string]*Token{}}
+	if tok != nil {
+		s.tokens[profile] = tok
+	}
+	return s
+}
+
+func (s *memStore) Load(profile string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, ok := s.tokens[profile]
+	if !ok {
+		return nil, ErrNoToken
+	}
+	cp := *tok
+	return &cp, nil
+}
+
+func (s *memStore) Save(profile string, tok *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *tok
+	s.tokens[profile] = &cp
+	s.saves++
+	return nil
+}
+
+func (s *memStore) Delete(profile string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[profile]; !ok {
+		return ErrNoToken
+	}
+	delete(s.tokens, profile)
+	return nil
+}
+
+func TestSource_Token(t *testing.T) {
+	tests := []struct {
+		name        string
+		stored      *Token
+		wantAccess  string
+		wantRefresh int
+		wantErr     error
+	}{
+		{
+			name:       "valid token used as is",
+			stored:     &Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(time.Hour)},
+			wantAccess: "access-1",
+		},
+		{
+			name:        "refreshes ahead of expiry",
+			stored:      &Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(10 * time.Second)},
+			wantAccess:  "refreshed-1",
+			wantRefresh: 1,
+		},
+		{
+			name:        "refreshes expired token",
+			stored:      &Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(-time.Minute)},
+			wantAccess:  "refreshed-1",
+			wantRefresh: 1,
+		},
+		{
+			name:       "near expiry without refresh token",
+			stored:     &Token{AccessToken: "access-1", Expiry: time.Now().Add(10 * time.Second)},
+			wantAccess: "access-1",
+		},
+		{
+			name:    "no stored token",
+			wantErr: ErrNoToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			store := newMemStore("default", tt.stored)
+			src := &Source{Client: issuer.client(), Store: store, Profile: "default"}
+
+			tok, err := src.Token(context.Background())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Token() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+			if tok.AccessToken != tt.wantAccess {
+				t.Errorf("AccessToken = %q, want %q", tok.AccessToken, tt.wantAccess)
+			}
+			if issuer.refreshes != tt.wantRefresh {
+				t.Errorf("refreshes = %d, want %d", issuer.refreshes, tt.wantRefresh)
+			}
+			if tt.wantRefresh > 0 {
+				saved, _ := store.Load("default")
+				if saved.AccessToken != tt.wantAccess || saved.RefreshToken != "refresh-1" {
+					t.Errorf("saved token = %+v, want refreshed access token with original refresh token", saved)
+				}
+			}
+		})
+	}
+}
+
+func TestSource_Refresh_singleFlight(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	release := make(chan struct{})
+	issuer.tokenHandler = func(w http.ResponseWriter, form url.Values) {
+		<-release
+		issuer.mu.Lock()
+		issuer.refreshes++
+		issuer.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{"access_token": "refreshed", "expires_in": 3600})
+	}
+	stale := &Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(time.Hour)}
+	src := &Source{Client: issuer.client(), Store: newMemStore("default", stale), Profile: "default"}
+	if _, err := src.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	results := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tok, err := src.Refresh(context.Background(), stale)
+			if err != nil {
+				t.Errorf("Refresh() error = %v", err)
+				return
+			}
+			results[i] = tok.AccessToken
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if issuer.refreshes != 1 {
+		t.Errorf("token endpoint called %d times, want 1", issuer.refreshes)
+	}
+	for i, got := range results {
+		if got != "refreshed" {
+			t.Errorf("results[%d] = %q, want refreshed", i, got)
+		}
+	}
+}
+
+func TestSource_Refresh_storeUpdated(t *testing.T) {
+	// 実行中の Source の裏で、別のプロセスが Store のトークンを更新した場合
+	tests := []struct {
+		name string
+		// saved は Source がトークンを読み込んだ後に別のプロセスが保存するトークン
+		saved *Token
+		// rotate は refresh-1 でのリフレッシュを拒否し、拒否する前に saved を保存するかどうか
+		rotate      bool
+		wantAccess  string
+		wantRefresh []string // トークンエンドポイントに送られたリフレッシュトークン
+	}{
+		{
+			name:       "uses the newer stored token",
+			saved:      &Token{AccessToken: "access-2", RefreshToken: "refresh-2", Expiry: time.Now().Add(time.Hour)},
+			wantAccess: "access-2",
+		},
+		{
+			name:        "refreshes with the newer stored refresh token",
+			saved:       &Token{AccessToken: "access-2", RefreshToken: "refresh-2", Expiry: time.Now().Add(10 * time.Second)},
+			wantAccess:  "refreshed",
+			wantRefresh: []string{"refresh-2"},
+		},
+		{
+			name:        "refresh token rotated by another process",
+			saved:       &Token{AccessToken: "access-2", RefreshToken: "refresh-2", Expiry: time.Now().Add(time.Hour)},
+			rotate:      true,
+			wantAccess:  "access-2",
+			wantRefresh: []string{"refresh-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			store := newMemStore("default", &Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(time.Hour)})
+			var sent []string
+			issuer.tokenHandler = func(w http.ResponseWriter, form url.Values) {
+				sent = append(sent, form.Get("refresh_token"))
+				if tt.rotate {
+					_ = store.Save("default", tt.saved)
+					writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+					return
+				}
+				writeJSON(w, http.StatusOK, map[string]any{"access_token": "refreshed", "expires_in": 3600})
+			}
+			src := &Source{Client: issuer.client(), Store: store, Profile: "default"}
+			stale, err := src.Token(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.rotate {
+				_ = store.Save("default", tt.saved)
+			}
+
+			// サーバーが stale に 401 を返した
+			tok, err := src.Refresh(context.Background(), stale)
+			if err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+			if tok.AccessToken != tt.wantAccess {
+				t.Errorf("AccessToken = %q, want %q", tok.AccessToken, tt.wantAccess)
+			}
+			if strings.Join(sent, ",") != strings.Join(tt.wantRefresh, ",") {
+				t.Errorf("refresh tokens sent = %v, want %v", sent, tt.wantRefresh)
+			}
+			if tok, _ := src.Token(context.Background()); tok.AccessToken != tt.wantAccess {
+				t.Errorf("Token() after Refresh = %q, want %q", tok.AccessToken, tt.wantAccess)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoToken はプロファイルのトークンが保存されていないことを表します。
+var ErrNoToken = errors.New("no stored token")
+
+// Store はプロファイルごとのトークンを JSON ファイルとして保存します。
+// ファイルは <Dir>/<profile>.json に 0600 で作成します。
+type Store struct {
+	Dir string
+}
+
+// DefaultStore は OS のユーザー設定ディレクトリ配下（例: ~/.config/mcp-bridge/tokens）を使う Store を返します。
+func DefaultStore() (*Store, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve user config dir: %w", err)
+	}
+	return &Store{Dir: filepath.Join(dir, "mcp-bridge", "tokens")}, nil
+}
+
+func (s *Store) path(profile string) (string, error) {
+	if profile == "" || profile == "." || profile == ".." || strings.ContainsAny(profile, `/\`) {
+		return "", fmt.Errorf("invalid profile name %q", profile)
+	}
+	return filepath.Join(s.Dir, profile+".json"), nil
+}
+
+// Load はプロファイルのトークンを読み込みます。保存されていない場合は ErrNoToken を返します。
+func (s *Store) Load(profile string) (*Token, error) {
+	path, err := s.path(profile)
+	if err != nil {
+		return nil, err
+	}
+	return readTokenFile(path)
+}
+
+// Save はプロファイルのトークンを保存します。
+func (s *Store) Save(profile string, tok *Token) error {
+	path, err := s.path(profile)
+	if err != nil {
+		return err
+	}
+	return writeTokenFile(path, tok)
+}
+
+// Delete はプロファイルのトークンを削除します。他のプロファイルのトークンには影響しません。
+// 保存されていない場合は ErrNoToken を返します。
+func (s *Store) Delete(profile string) error {
+	path, err := s.path(profile)
+	if err != nil {
+		return err
+	}
+	return removeTokenFile(path)
+}
+
+// FileStore は設定の token_file で指定された単一のファイルを読み書きします。プロファイル名は使いません。
+type FileStore struct {
+	Path string
+}
+
+// Load はトークンファイルを読み込みます。ファイルがない場合は ErrNoToken を返します。
+func (s *FileStore) Load(string) (*Token, error) {
+	return readTokenFile(s.Path)
+}
+
+// Save はトークンファイルを上書きします。
+func (s *FileStore) Save(_ string, tok *Token) error {
+	return writeTokenFile(s.Path, tok)
+}
+
+// Delete はトークンファイルを削除します。ファイルがない場合は ErrNoToken を返します。
+func (s *FileStore) Delete(string) error {
+	return removeTokenFile(s.Path)
+}
+
+func readTokenFile(path string) (*Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoToken
+		}
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	var tok Token
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return nil, fmt.Errorf("failed to parse token file %s: %w", path, err)
+	}
+	return &tok, nil
+}
+
+func removeTokenFile(path string) error {
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoToken
+		}
+		return fmt.Errorf("failed to delete token file: %w", err)
+	}
+	return nil
+}
+
+// writeTokenFile は一時ファイルに書き込んでからリネームするため、書き込み途中のファイルを他プロセスが読むことはありません。
+func writeTokenFile(path string, tok *Token) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create token dir: %w", err)
+	}
+	data, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create token file: %w", err)
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // リネーム成功後は存在しないので無視される
+	// os.CreateTemp は 0600 で作成する
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save token file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestStore_SaveLoad(t *testing.T) {
+	store := &Store{Dir: filepath.Join(t.TempDir(), "tokens")}
+	tok := &Token{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		IDToken:      "id",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(time.Hour).Truncate(time.Second),
+	}
+	if err := store.Save("default", tok); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	got, err := store.Load("default")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.AccessToken != tok.AccessToken || got.RefreshToken != tok.RefreshToken || !got.Expiry.Equal(tok.Expiry) {
+		t.Errorf("Load() = %+v, want %+v", got, tok)
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(store.Dir, "default.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("token file perm = %o, want 600", perm)
+		}
+	}
+
+	if _, err := store.Load("other"); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Load(other) error = %v, want ErrNoToken", err)
+	}
+}
+
+func TestStore_invalidProfile(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	for _, profile := range []string{"", ".", "..", "../x", `a\b`} {
+		if err := store.Save(profile, &Token{AccessToken: "x"}); err == nil {
+			t.Errorf("Save(%q) expected error", profile)
+		}
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	for _, profile := range []string{"default", "staging"} {
+		if err := store.Save(profile, &Token{AccessToken: profile}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Delete("staging"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Load("staging"); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Load(staging) after Delete error = %v, want ErrNoToken", err)
+	}
+	if tok, err := store.Load("default"); err != nil || tok.AccessToken != "default" {
+		t.Errorf("Load(default) = %+v, %v; other profile must be left alone", tok, err)
+	}
+	if err := store.Delete("staging"); !errors.Is(err, ErrNoToken) {
+		t.Errorf("second Delete() error = %v, want ErrNoToken", err)
+	}
+}
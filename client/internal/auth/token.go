@@ -0,0 +1,51 @@
+// Package auth は Cognito などの OIDC プロバイダに対するログインフローと、取得したトークンのローカル保存を扱います。
+package auth
+
+import (
+	"time"
+)
+
+// Token はトークンエンドポイントから取得したトークン一式です。
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Valid はアクセストークンがあり、期限切れでないかどうかを返します。
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && !t.ExpiresWithin(0)
+}
+
+// ExpiresWithin はアクセストークンが d 以内に期限切れになるかどうかを返します。
+// Expiry が未設定の場合は期限なしとみなします。
+func (t *Token) ExpiresWithin(d time.Duration) bool {
+	if t.Expiry.IsZero() {
+		return false
+	}
+	return time.Now().Add(d).After(t.Expiry)
+}
+
+// tokenResponse はトークンエンドポイントのレスポンス（RFC 6749 5.1）です。
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+func (r *tokenResponse) token() *Token {
+	tok := &Token{
+		AccessToken:  r.AccessToken,
+		RefreshToken: r.RefreshToken,
+		IDToken:      r.IDToken,
+		TokenType:    r.TokenType,
+	}
+	if r.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	return tok
+}
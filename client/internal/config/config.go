@@ -3,56 +3,344 @@ package config
 import (
 	"fmt"
 	"net/url"
+	"os"
+	"path"
+	"time"
 
 	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
 )
 
 // DefaultSSEURL はデフォルトのSSEエンドポイントURLです。
 const DefaultSSEURL = "http://localhost:8080/sse"
 
+// Transport は MCP サーバーとの通信方式です。
+const (
+	// TransportSSE は旧来の HTTP+SSE（GET /sse でストリームを受け、別エンドポイントへ POST する）方式です。
+	TransportSSE = "sse"
+	// TransportStreamableHTTP は Streamable HTTP（単一エンドポイントへの POST が JSON または SSE を返す）方式です。
+	TransportStreamableHTTP = "streamable-http"
+	// TransportAuto はサーバーを試して Streamable HTTP、だめなら SSE を使います。
+	TransportAuto = "auto"
+)
+
+// DefaultMaxConcurrency はサーバーへ同時に送るリクエスト数のデフォルトです。
+const DefaultMaxConcurrency = 8
+
+// DefaultMaxMessageBytes は stdin から受け付ける 1 メッセージ（1 行）の大きさのデフォルトの上限です。
+const DefaultMaxMessageBytes = 32 << 20
+
+// DefaultShutdownGrace は終了時に実行中のリクエストの完了を待つ時間のデフォルトです。
+const DefaultShutdownGrace = 5 * time.Second
+
+// DefaultRequestTimeout は 1 リクエストのレスポンスを待つ時間のデフォルトです。
+const DefaultRequestTimeout = 60 * time.Second
+
+// DefaultProfile は Profile が未指定のときに使うプロファイル名です。
+const DefaultProfile = "default"
+
+// DefaultScopes は OIDC ログイン時にデフォルトで要求するスコープです。
+var DefaultScopes = []string{"openid", "email", "profile"}
+
 // Config は接続先や認証プロファイルなどの設定を保持します。
 type Config struct {
 	// URL はMCPサーバーのSSEエンドポイントURL（例: http://localhost:8080/sse）
 	URL string
-	// Profile は認証プロファイル名（将来のCognito用、今回は未使用）
+	// Profile は認証プロファイル名。トークンの保存先と Auth の解決に使う。
 	Profile string
 	// Debug はデバッグログを有効にするか
 	Debug bool
+	// Transport は通信方式（sse / streamable-http / auto）
+	Transport string
+	// MaxConcurrency はサーバーへ同時に送るリクエスト数の上限。0 以下の場合は DefaultMaxConcurrency を使う。
+	MaxConcurrency int
+	// MaxMessageBytes は stdin から受け付ける 1 メッセージ（1 行）の大きさの上限。0 以下の場合は DefaultMaxMessageBytes を使う。
+	MaxMessageBytes int
+	// ShutdownGrace は stdin が閉じられたりシグナルを受けたりした後、実行中のリクエストの完了を待つ時間。0 以下の場合は DefaultShutdownGrace を使う。
+	ShutdownGrace time.Duration
+	// Notifications はサーバーからの通知のうち stdout へ転送するものの絞り込み
+	Notifications NotificationFilter
+	// Timeouts はリクエストのレスポンスを待つ時間
+	Timeouts Timeouts
+	// Auth は Profile に対応する OIDC 認証設定
+	Auth AuthConfig
+}
+
+// NotificationFilter はサーバーからの通知を method で絞り込みます。
+// パターンは path.Match の書式です（例: notifications/tools/*）。
+type NotificationFilter struct {
+	// Include が空でない場合、いずれかに一致する通知だけを転送する
+	Include []string
+	// Exclude のいずれかに一致する通知は転送しない（Include より優先）
+	Exclude []string
+}
+
+// Allows は method の通知を転送するかどうかを返します。
+func (f NotificationFilter) Allows(method string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, method) {
+		return false
+	}
+	return !matchAny(f.Exclude, method)
+}
+
+// validate はパターンの書式を検証します。
+func (f NotificationFilter) validate() error {
+	for _, p := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid notification pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Timeouts はリクエストのレスポンスを待つ時間です。ツール名 → method → Default の順に探します。
+// 0 はタイムアウトしない（レスポンスを待ち続ける）ことを表します。
+// MCP のツール名と method は大文字・小文字を区別するので、Methods と Tools のキーとは完全に一致するものだけを使います。
+type Timeouts struct {
+	// Default は Methods・Tools に無いリクエストのタイムアウト。0 の場合はタイムアウトしない。
+	// 設定ファイル・フラグで指定しなければ DefaultRequestTimeout になる。
+	Default time.Duration
+	// Methods は JSON-RPC の method ごとのタイムアウト（例: tools/list）
+	Methods map[string]time.Duration
+	// Tools は tools/call のツール名（params.name）ごとのタイムアウト（例: search_documents）
+	Tools map[string]time.Duration
+}
+
+// For は method のリクエストのタイムアウトを返します。tool は tools/call の場合のツール名で、それ以外では空にします。
+// 0 を返した場合はタイムアウトしません。
+func (t Timeouts) For(method, tool string) time.Duration {
+	if tool != "" {
+		if d, ok := t.Tools[tool]; ok {
+			return d
+		}
+	}
+	if d, ok := t.Methods[method]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// ParseTimeouts は名前と時間の文字列（例: "tools/list" → "10s"）の組を解析します。キーはそのまま使います。
+// "0s" はその method・ツールをタイムアウトさせないことを表します。
+func ParseTimeouts(m map[string]string) (map[string]time.Duration, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]time.Duration, len(m))
+	for name, v := range m {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout for %q: %w", name, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("timeout for %q must not be negative, got %s", name, v)
+		}
+		out[name] = d
+	}
+	return out, nil
+}
+
+func matchAny(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthConfig は Cognito などの OIDC プロバイダへの認証設定を保持します。
+type AuthConfig struct {
+	// Issuer は OIDC Issuer URL（例: https://cognito-idp.ap-northeast-1.amazonaws.com/<pool-id>）
+	Issuer string
+	// ClientID は OAuth2 クライアント ID（パブリッククライアント想定）
+	ClientID string
+	// Scopes は要求するスコープ
+	Scopes []string
+	// RedirectPort はブラウザログインのループバックリダイレクトで待ち受けるポート。0 の場合は空きポートを使う。
+	RedirectPort int
+	// TokenFile はトークンを読み書きするファイル（profiles.<name>.token_file、default プロファイルはトップレベルの token_file も可）。
+	// 空の場合は login が使う既定の保存先（プロファイルごと）を使う。
+	TokenFile string
+}
+
+// Enabled は認証設定が有効（Issuer と ClientID が揃っている）かどうかを返します。
+func (a AuthConfig) Enabled() bool {
+	return a.Issuer != "" && a.ClientID != ""
 }
 
 // Load はviperから設定を読み込み、Configを返します。
 // フラグや環境変数で上書き可能です。
 func Load() (*Config, error) {
+	return LoadProfile("")
+}
+
+// LoadProfile は Load と同様に設定を読み込みます。profile が空でない場合は設定ファイル・環境変数の profile より優先し、
+// そのプロファイルの認証設定を解決します。
+func LoadProfile(profile string) (*Config, error) {
 	v := viper.New()
 
 	v.SetDefault("url", DefaultSSEURL)
 	v.SetDefault("profile", "")
 	v.SetDefault("debug", false)
+	v.SetDefault("transport", TransportAuto)
+	v.SetDefault("max_concurrency", DefaultMaxConcurrency)
+	v.SetDefault("max_message_bytes", DefaultMaxMessageBytes)
+	v.SetDefault("shutdown_grace", DefaultShutdownGrace)
+	v.SetDefault("timeouts.default", DefaultRequestTimeout)
 
-	// 環境変数: MCP_BRIDGE_URL, MCP_BRIDGE_PROFILE, MCP_BRIDGE_DEBUG
+	// 環境変数: MCP_BRIDGE_URL, MCP_BRIDGE_PROFILE, MCP_BRIDGE_DEBUG, MCP_BRIDGE_TRANSPORT
 	v.SetEnvPrefix("MCP_BRIDGE")
 	v.AutomaticEnv()
 
 	// 設定ファイル（任意）: .mcp-bridge.yaml など
+	setConfigSearch(v)
+	_ = v.ReadInConfig() // ファイルがなくても続行
+
+	if profile != "" {
+		v.Set("profile", profile)
+	}
+	cfg, err := fromViper(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// setConfigSearch は設定ファイル（カレントまたは $HOME の .mcp-bridge.yaml）の探し方を v に設定します。
+func setConfigSearch(v *viper.Viper) {
 	v.SetConfigName(".mcp-bridge")
 	v.SetConfigType("yaml")
 	v.AddConfigPath(".")
 	v.AddConfigPath("$HOME")
-	_ = v.ReadInConfig() // ファイルがなくても続行
+}
+
+// HomeFile は $HOME の設定ファイル（~/.mcp-bridge.yaml）のパスを返します。見つからない場合は空文字列を返します。
+// FileUsed と異なりカレントディレクトリは探しません（プロジェクトに置かれた設定ファイルを誤って消さないよう、削除の対象を探す場合に使う）。
+func HomeFile() string {
+	v := viper.New()
+	v.SetConfigName(".mcp-bridge")
+	v.SetConfigType("yaml")
+	v.AddConfigPath("$HOME")
+	_ = v.ReadInConfig()
+	return v.ConfigFileUsed()
+}
+
+// FileUsed は Load が読み込む設定ファイルのパスを返します。見つからない場合は空文字列を返します。
+// 内容が YAML として解釈できないファイルでもパスを返します。
+func FileUsed() string {
+	v := viper.New()
+	setConfigSearch(v)
+	_ = v.ReadInConfig()
+	return v.ConfigFileUsed()
+}
 
+// fromViper は viper の値から Config を組み立てます。
+//
+//	timeouts:
+//	  default: 60s
+//	  methods:
+//	    tools/list: 10s
+//	  tools:
+//	    search_documents: 2m
+func fromViper(v *viper.Viper) (*Config, error) {
 	cfg := &Config{
-		URL:     v.GetString("url"),
-		Profile: v.GetString("profile"),
-		Debug:   v.GetBool("debug"),
+		URL:             v.GetString("url"),
+		Profile:         v.GetString("profile"),
+		Debug:           v.GetBool("debug"),
+		Transport:       v.GetString("transport"),
+		MaxConcurrency:  v.GetInt("max_concurrency"),
+		MaxMessageBytes: v.GetInt("max_message_bytes"),
+		ShutdownGrace:   v.GetDuration("shutdown_grace"),
+		Notifications: NotificationFilter{
+			Include: v.GetStringSlice("notifications.include"),
+			Exclude: v.GetStringSlice("notifications.exclude"),
+		},
 	}
+	cfg.Auth = authFromViper(v, cfg.ProfileName())
 
-	if err := cfg.Validate(); err != nil {
-		return nil, err
+	cfg.Timeouts.Default = v.GetDuration("timeouts.default")
+	methods, tools := timeoutMaps(v)
+	var err error
+	if cfg.Timeouts.Methods, err = ParseTimeouts(methods); err != nil {
+		return nil, fmt.Errorf("timeouts.methods: %w", err)
+	}
+	if cfg.Timeouts.Tools, err = ParseTimeouts(tools); err != nil {
+		return nil, fmt.Errorf("timeouts.tools: %w", err)
 	}
-
 	return cfg, nil
 }
 
+// timeoutMaps は timeouts.methods と timeouts.tools をキーの綴りのまま返します。
+// viper はマップのキーを小文字にしてしまい、searchDocuments のようなツール名と一致しなくなるので、設定ファイルを直接デコードします。
+// 設定ファイルが無いか読めない場合は viper の値を使います。
+func timeoutMaps(v *viper.Viper) (methods, tools map[string]string) {
+	methods, tools = v.GetStringMapString("timeouts.methods"), v.GetStringMapString("timeouts.tools")
+	file := v.ConfigFileUsed()
+	if file == "" {
+		return methods, tools
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return methods, tools
+	}
+	var raw struct {
+		Timeouts struct {
+			Methods map[string]string `yaml:"methods"`
+			Tools   map[string]string `yaml:"tools"`
+		} `yaml:"timeouts"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return methods, tools
+	}
+	return raw.Timeouts.Methods, raw.Timeouts.Tools
+}
+
+// authFromViper はプロファイルの認証設定を解決します。
+// profiles.<name>.* が優先され、未設定の項目はトップレベルの issuer / client_id などを使います。
+// token_file だけは、複数のプロファイルが 1 つのファイルを上書きし合わないよう、トップレベルの値を default プロファイルにしか使いません。
+//
+//	issuer: https://issuer.example.com
+//	client_id: abc
+//	profiles:
+//	  staging:
+//	    issuer: https://staging-issuer.example.com
+//	    client_id: def
+func authFromViper(v *viper.Viper, profile string) AuthConfig {
+	get := func(key string) string {
+		if s := v.GetString("profiles." + profile + "." + key); s != "" {
+			return s
+		}
+		return v.GetString(key)
+	}
+	a := AuthConfig{
+		Issuer:    get("issuer"),
+		ClientID:  get("client_id"),
+		TokenFile: v.GetString("profiles." + profile + ".token_file"),
+	}
+	if a.TokenFile == "" && profile == DefaultProfile {
+		a.TokenFile = v.GetString("token_file")
+	}
+	if port := v.GetInt("profiles." + profile + ".redirect_port"); port != 0 {
+		a.RedirectPort = port
+	} else {
+		a.RedirectPort = v.GetInt("redirect_port")
+	}
+	if scopes := v.GetStringSlice("profiles." + profile + ".scopes"); len(scopes) > 0 {
+		a.Scopes = scopes
+	} else if scopes := v.GetStringSlice("scopes"); len(scopes) > 0 {
+		a.Scopes = scopes
+	} else {
+		a.Scopes = append([]string(nil), DefaultScopes...)
+	}
+	return a
+}
+
 // Validate は設定の妥当性を検証します。
 func (c *Config) Validate() error {
 	if c.URL == "" {
@@ -65,7 +353,47 @@ func (c *Config) Validate() error {
 	if u.Scheme != "http" && u.Scheme != "https" {
 		return fmt.Errorf("url scheme must be http or https, got %q", u.Scheme)
 	}
-	return nil
+	switch c.Transport {
+	case "", TransportSSE, TransportStreamableHTTP, TransportAuto:
+	default:
+		return fmt.Errorf("transport must be one of %s, %s or %s, got %q", TransportSSE, TransportStreamableHTTP, TransportAuto, c.Transport)
+	}
+	if c.Timeouts.Default < 0 {
+		return fmt.Errorf("default timeout must not be negative, got %s", c.Timeouts.Default)
+	}
+	return c.Notifications.validate()
+}
+
+// MaxConcurrencyOrDefault は MaxConcurrency を返します。0 以下の場合は DefaultMaxConcurrency を返します。
+func (c *Config) MaxConcurrencyOrDefault() int {
+	if c.MaxConcurrency <= 0 {
+		return DefaultMaxConcurrency
+	}
+	return c.MaxConcurrency
+}
+
+// MaxMessageBytesOrDefault は MaxMessageBytes を返します。0 以下の場合は DefaultMaxMessageBytes を返します。
+func (c *Config) MaxMessageBytesOrDefault() int {
+	if c.MaxMessageBytes <= 0 {
+		return DefaultMaxMessageBytes
+	}
+	return c.MaxMessageBytes
+}
+
+// ShutdownGraceOrDefault は ShutdownGrace を返します。0 以下の場合は DefaultShutdownGrace を返します。
+func (c *Config) ShutdownGraceOrDefault() time.Duration {
+	if c.ShutdownGrace <= 0 {
+		return DefaultShutdownGrace
+	}
+	return c.ShutdownGrace
+}
+
+// ProfileName は Profile を返します。未指定の場合は DefaultProfile を返します。
+func (c *Config) ProfileName() string {
+	if c.Profile == "" {
+		return DefaultProfile
+	}
+	return c.Profile
 }
 
 // BaseURL はSSE URLからベースURL（スキーム＋ホスト）を返します。
@@ -78,9 +406,3 @@ func (c *Config) BaseURL() string {
 	u.Fragment = ""
 	return u.String()
 }
-
-// McpPath はPOST先のJSON-RPCパスを返します。サーバーがSSEで通知する場合はそちらを優先する想定。
-// デフォルトは /mcp です。
-func (c *Config) McpPath() string {
-	return "/mcp"
-}
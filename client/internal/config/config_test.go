@@ -1,30 +1,80 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/spf13/viper"
 )
 
 func TestConfig_Validate(t *testing.T) {
 	tests := []struct {
-		name    string
-		url     string
-		wantErr bool
+		name      string
+		url       string
+		transport string
+		wantErr   bool
 	}{
-		{"empty url", "", true},
-		{"invalid url", "://broken", true},
-		{"invalid scheme", "ftp://localhost/sse", true},
-		{"http ok", "http://localhost:8080/sse", false},
-		{"https ok", "https://api.example.com/sse", false},
+		{"empty url", "", "", true},
+		{"invalid url", "://broken", "", true},
+		{"invalid scheme", "ftp://localhost/sse", "", true},
+		{"http ok", "http://localhost:8080/sse", "", false},
+		{"https ok", "https://api.example.com/sse", "", false},
+		{"transport sse", "http://localhost:8080/sse", TransportSSE, false},
+		{"transport streamable-http", "http://localhost:8080/mcp", TransportStreamableHTTP, false},
+		{"transport auto", "http://localhost:8080/sse", TransportAuto, false},
+		{"unknown transport", "http://localhost:8080/sse", "websocket", true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cfg := &Config{URL: tt.url}
+			cfg := &Config{URL: tt.url, Transport: tt.transport}
 			err := cfg.Validate()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
+
+	t.Run("bad notification pattern", func(t *testing.T) {
+		cfg := &Config{URL: "http://localhost:8080/sse", Notifications: NotificationFilter{Exclude: []string{"notifications/["}}}
+		if err := cfg.Validate(); err == nil {
+			t.Error("Validate() expected error for a malformed pattern")
+		}
+	})
+
+	t.Run("negative default timeout", func(t *testing.T) {
+		cfg := &Config{URL: "http://localhost:8080/sse", Timeouts: Timeouts{Default: -time.Second}}
+		if err := cfg.Validate(); err == nil {
+			t.Error("Validate() expected error for a negative timeout")
+		}
+	})
+}
+
+func TestNotificationFilter_Allows(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter NotificationFilter
+		method string
+		want   bool
+	}{
+		{"no filter", NotificationFilter{}, "notifications/progress", true},
+		{"included", NotificationFilter{Include: []string{"notifications/progress"}}, "notifications/progress", true},
+		{"not included", NotificationFilter{Include: []string{"notifications/progress"}}, "notifications/message", false},
+		{"glob", NotificationFilter{Include: []string{"notifications/*/list_changed"}}, "notifications/tools/list_changed", true},
+		{"glob does not cross slash", NotificationFilter{Include: []string{"notifications/*"}}, "notifications/tools/list_changed", false},
+		{"excluded", NotificationFilter{Exclude: []string{"notifications/message"}}, "notifications/message", false},
+		{"exclude wins", NotificationFilter{Include: []string{"notifications/*"}, Exclude: []string{"notifications/message"}}, "notifications/message", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Allows(tt.method); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
 }
 
 func TestConfig_BaseURL(t *testing.T) {
@@ -46,9 +96,265 @@ func TestConfig_BaseURL(t *testing.T) {
 	}
 }
 
-func TestConfig_McpPath(t *testing.T) {
-	cfg := &Config{}
-	if got := cfg.McpPath(); got != "/mcp" {
-		t.Errorf("McpPath() = %q, want /mcp", got)
+func TestConfig_ProfileName(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{"", DefaultProfile},
+		{"staging", "staging"},
 	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			cfg := &Config{Profile: tt.profile}
+			if got := cfg.ProfileName(); got != tt.want {
+				t.Errorf("ProfileName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthFromViper(t *testing.T) {
+	const yaml = `
+issuer: https://issuer.example.com
+client_id: top-client
+redirect_port: 53682
+token_file: /tokens/top.json
+profiles:
+  staging:
+    issuer: https://staging.example.com
+    client_id: staging-client
+    scopes: [openid, groups]
+    token_file: /tokens/staging.json
+  partial:
+    client_id: partial-client
+`
+	tests := []struct {
+		name       string
+		profile    string
+		wantIssuer string
+		wantClient string
+		wantScopes []string
+		wantPort   int
+		// トップレベルの token_file は default プロファイルだけに使い、他のプロファイルとトークンを共有しない
+		wantTokenFile string
+	}{
+		{"top-level fallback", "default", "https://issuer.example.com", "top-client", DefaultScopes, 53682, "/tokens/top.json"},
+		{"profile override", "staging", "https://staging.example.com", "staging-client", []string{"openid", "groups"}, 53682, "/tokens/staging.json"},
+		{"partial profile", "partial", "https://issuer.example.com", "partial-client", DefaultScopes, 53682, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(yaml)); err != nil {
+				t.Fatalf("ReadConfig: %v", err)
+			}
+			got := authFromViper(v, tt.profile)
+			if got.Issuer != tt.wantIssuer {
+				t.Errorf("Issuer = %q, want %q", got.Issuer, tt.wantIssuer)
+			}
+			if got.ClientID != tt.wantClient {
+				t.Errorf("ClientID = %q, want %q", got.ClientID, tt.wantClient)
+			}
+			if strings.Join(got.Scopes, " ") != strings.Join(tt.wantScopes, " ") {
+				t.Errorf("Scopes = %v, want %v", got.Scopes, tt.wantScopes)
+			}
+			if got.RedirectPort != tt.wantPort {
+				t.Errorf("RedirectPort = %d, want %d", got.RedirectPort, tt.wantPort)
+			}
+			if got.TokenFile != tt.wantTokenFile {
+				t.Errorf("TokenFile = %q, want %q", got.TokenFile, tt.wantTokenFile)
+			}
+			if !got.Enabled() {
+				t.Error("Enabled() = false, want true")
+			}
+		})
+	}
+}
+
+func TestConfig_MaxConcurrencyOrDefault(t *testing.T) {
+	tests := []struct {
+		in   int
+		want int
+	}{
+		{0, DefaultMaxConcurrency},
+		{-1, DefaultMaxConcurrency},
+		{1, 1},
+		{32, 32},
+	}
+	for _, tt := range tests {
+		cfg := &Config{MaxConcurrency: tt.in}
+		if got := cfg.MaxConcurrencyOrDefault(); got != tt.want {
+			t.Errorf("MaxConcurrencyOrDefault() with %d = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_MaxMessageBytesOrDefault(t *testing.T) {
+	tests := []struct {
+		in   int
+		want int
+	}{
+		{0, DefaultMaxMessageBytes},
+		{-1, DefaultMaxMessageBytes},
+		{4096, 4096},
+	}
+	for _, tt := range tests {
+		cfg := &Config{MaxMessageBytes: tt.in}
+		if got := cfg.MaxMessageBytesOrDefault(); got != tt.want {
+			t.Errorf("MaxMessageBytesOrDefault() with %d = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_ShutdownGraceOrDefault(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want time.Duration
+	}{
+		{0, DefaultShutdownGrace},
+		{-time.Second, DefaultShutdownGrace},
+		{30 * time.Second, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		cfg := &Config{ShutdownGrace: tt.in}
+		if got := cfg.ShutdownGraceOrDefault(); got != tt.want {
+			t.Errorf("ShutdownGraceOrDefault() with %s = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTimeouts_For(t *testing.T) {
+	timeouts := Timeouts{
+		Default: 30 * time.Second,
+		Methods: map[string]time.Duration{"tools/list": 5 * time.Second, "tools/call": time.Minute},
+		Tools:   map[string]time.Duration{"search_documents": 2 * time.Minute},
+	}
+	tests := []struct {
+		name   string
+		t      Timeouts
+		method string
+		tool   string
+		want   time.Duration
+	}{
+		{"method", timeouts, "tools/list", "", 5 * time.Second},
+		{"tool", timeouts, "tools/call", "search_documents", 2 * time.Minute},
+		{"tool name is case-sensitive", timeouts, "tools/call", "Search_Documents", time.Minute},
+		{"unknown tool falls back to method", timeouts, "tools/call", "other", time.Minute},
+		{"default", timeouts, "ping", "", 30 * time.Second},
+		{"no default timeout", Timeouts{}, "ping", "", 0},
+		{"method without timeout", Timeouts{Default: time.Minute, Methods: map[string]time.Duration{"tools/call": 0}}, "tools/call", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.t.For(tt.method, tt.tool); got != tt.want {
+				t.Errorf("For(%q, %q) = %s, want %s", tt.method, tt.tool, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromViper_timeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    Timeouts
+		wantErr bool
+	}{
+		{
+			name: "methods and mixed-case tool name",
+			yaml: "timeouts:\n  default: 45s\n  methods:\n    tools/list: 10s\n  tools:\n    searchDocuments: 2m\n",
+			want: Timeouts{
+				Default: 45 * time.Second,
+				Methods: map[string]time.Duration{"tools/list": 10 * time.Second},
+				Tools:   map[string]time.Duration{"searchDocuments": 2 * time.Minute},
+			},
+		},
+		{
+			name:    "invalid duration",
+			yaml:    "timeouts:\n  tools:\n    search_documents: soon\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative duration",
+			yaml:    "timeouts:\n  methods:\n    ping: -1s\n",
+			wantErr: true,
+		},
+		{
+			name: "zero disables the timeout",
+			yaml: "timeouts:\n  default: 0s\n  methods:\n    ping: 0s\n",
+			want: Timeouts{Methods: map[string]time.Duration{"ping": 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), ".mcp-bridge.yaml")
+			if err := os.WriteFile(file, []byte(tt.yaml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			v := viper.New()
+			v.SetConfigFile(file)
+			if err := v.ReadInConfig(); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := fromViper(v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fromViper() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(cfg.Timeouts, tt.want) {
+				t.Errorf("Timeouts = %+v, want %+v", cfg.Timeouts, tt.want)
+			}
+			// 設定ファイルに書いた綴りのツール名で引ける
+			for tool, want := range tt.want.Tools {
+				if got := cfg.Timeouts.For("tools/call", tool); got != want {
+					t.Errorf("For(tools/call, %q) = %v, want %v", tool, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHomeFile(t *testing.T) {
+	home, work := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	// カレントディレクトリの設定ファイルは FileUsed では見つかるが、HomeFile の対象にはならない
+	local := filepath.Join(work, ".mcp-bridge.yaml")
+	if err := os.WriteFile(local, []byte("url: http://localhost:8080/sse\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := HomeFile(); got != "" {
+		t.Errorf("HomeFile() = %q, want empty without ~/.mcp-bridge.yaml", got)
+	}
+	if got := FileUsed(); !sameFile(t, got, local) {
+		t.Errorf("FileUsed() = %q, want %q", got, local)
+	}
+
+	want := filepath.Join(home, ".mcp-bridge.yaml")
+	if err := os.WriteFile(want, []byte("url: http://localhost:8080/sse\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := HomeFile(); !sameFile(t, got, want) {
+		t.Errorf("HomeFile() = %q, want %q", got, want)
+	}
+}
+
+// sameFile は a と b が同じファイルを指すかどうかを返します（TMPDIR がシンボリックリンクの環境向け）。
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	fa, errA := os.Stat(a)
+	fb, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(fa, fb)
 }
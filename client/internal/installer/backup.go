@@ -0,0 +1,118 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultKeepBackups は設定ファイルごとに残すバックアップの数のデフォルトです。
+const DefaultKeepBackups = 5
+
+const (
+	// backupSuffix はバックアップのファイル名の末尾です（例: claude_desktop_config.json.20261017T093015.123456789Z.bak）。
+	backupSuffix = ".bak"
+	// backupTimeFormat はバックアップのファイル名に入れる UTC の時刻の書式です。固定長なので名前の順が作成順になります。
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// ErrNoBackup は戻せるバックアップが無いことを表します。
+var ErrNoBackup = errors.New("バックアップがありません")
+
+// RollbackResult は Rollback で行った変更です。
+type RollbackResult struct {
+	// ConfigPath は戻した設定ファイルのパス
+	ConfigPath string
+	// Backup は戻したバックアップのパス（戻した後に削除済み）
+	Backup string
+}
+
+// Rollback は設定ファイルを最も新しいバックアップの内容に戻し、そのバックアップを削除します。
+// 繰り返し呼ぶと、さらに前のバックアップへ順に戻ります。バックアップが無い場合は ErrNoBackup を返します。
+func (s *Service) Rollback() (*RollbackResult, error) {
+	configPath, err := s.configPath()
+	var nf *NotFoundError
+	if errors.As(err, &nf) {
+		return nil, ErrNoBackup
+	}
+	if err != nil {
+		return nil, err
+	}
+	// バックアップはシンボリックリンクのリンク先と同じディレクトリに作られる
+	path := realPath(configPath)
+
+	backups, err := listBackups(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoBackup, configPath)
+	}
+	latest := backups[len(backups)-1]
+
+	data, err := os.ReadFile(latest)
+	if err != nil {
+		return nil, fmt.Errorf("バックアップの読み取りに失敗しました: %w", err)
+	}
+	if err := s.writeFileAtomic(path, data); err != nil {
+		return nil, fmt.Errorf("設定ファイルの書き込みに失敗しました: %w", err)
+	}
+	if err := os.Remove(latest); err != nil {
+		return nil, fmt.Errorf("戻したバックアップの削除に失敗しました: %w", err)
+	}
+	return &RollbackResult{ConfigPath: configPath, Backup: latest}, nil
+}
+
+// backup は設定ファイル path の書き換え前の内容 data を、時刻付きの名前で path と同じディレクトリに保存し、
+// 古いバックアップを KeepBackups 個まで減らします。
+func (s *Service) backup(path string, data []byte) error {
+	name := path + "." + time.Now().UTC().Format(backupTimeFormat) + backupSuffix
+	if err := s.writeFileAtomic(name, data); err != nil {
+		return fmt.Errorf("設定ファイルのバックアップに失敗しました: %w", err)
+	}
+
+	keep := s.KeepBackups
+	if keep <= 0 {
+		keep = DefaultKeepBackups
+	}
+	backups, err := listBackups(path)
+	if err != nil {
+		return err
+	}
+	for _, old := range backups[:max(0, len(backups)-keep)] {
+		if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("古いバックアップの削除に失敗しました: %w", err)
+		}
+	}
+	return nil
+}
+
+// listBackups は設定ファイル path のバックアップを古い順に返します。
+func listBackups(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("バックアップの検索に失敗しました: %w", err)
+	}
+	prefix := filepath.Base(path) + "."
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), backupSuffix)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(path), name))
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
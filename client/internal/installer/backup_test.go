@@ -0,0 +1,200 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// original は書式やコメントを含む、書き換え前の設定ファイルです。
+const original = `{
+    // keep me
+    "theme": "dark",
+    "mcpServers": {"other": {"command": "other"}}
+}
+`
+
+func TestService_writeConfig_failsPartway(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w io.Writer, data []byte) error
+	}{
+		{
+			name: "error after half of the data",
+			write: func(w io.Writer, data []byte) error {
+				if _, err := w.Write(data[:len(data)/2]); err != nil {
+					return err
+				}
+				return errors.New("disk full")
+			},
+		},
+		{
+			name: "nothing written",
+			write: func(io.Writer, []byte) error {
+				return errors.New("disk full")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, "claude_desktop_config.json")
+			if err := os.WriteFile(configPath, []byte(original), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			svc := &Service{ConfigPath: configPath}
+			svc.write = tt.write
+			if err := svc.Install("http://localhost:8080/sse", "default", "/usr/local/bin/mcp-bridge"); err == nil {
+				t.Fatal("Install() expected error")
+			}
+
+			data, err := os.ReadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != original {
+				t.Errorf("config changed after failed write:\n%s", data)
+			}
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				if strings.HasSuffix(e.Name(), ".tmp") {
+					t.Errorf("temporary file left behind: %s", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestService_writeConfig_keepsMode(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "claude_desktop_config.json")
+	if err := os.WriteFile(configPath, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{ConfigPath: configPath}
+	if err := svc.Install("http://localhost:8080/sse", "default", "/usr/local/bin/mcp-bridge"); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	fi, err := os.Stat(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode().Perm(); got != 0644 {
+		t.Errorf("mode = %v, want 0644", got)
+	}
+}
+
+func TestService_backups(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "claude_desktop_config.json")
+	svc := &Service{ConfigPath: configPath, KeepBackups: 2}
+
+	// 新規作成時はバックアップを作らない
+	install(t, svc, 0)
+	if backups := mustListBackups(t, configPath); len(backups) != 0 {
+		t.Errorf("backups after first install = %v, want none", backups)
+	}
+	want, _ := os.ReadFile(configPath)
+
+	// 内容が変わらない場合は書き込まないのでバックアップも作らない
+	install(t, svc, 0)
+	if backups := mustListBackups(t, configPath); len(backups) != 0 {
+		t.Errorf("backups after unchanged install = %v, want none", backups)
+	}
+
+	for i := 1; i <= 3; i++ {
+		install(t, svc, i)
+		if i == 1 {
+			want, _ = os.ReadFile(configPath)
+		}
+	}
+	backups := mustListBackups(t, configPath)
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want 2", backups)
+	}
+	// 残るのは 2 回目と 3 回目の書き換え前の内容（古いものから削除される）
+	got, _ := os.ReadFile(backups[0])
+	if string(got) != string(want) {
+		t.Errorf("oldest kept backup = %s, want %s", got, want)
+	}
+}
+
+func TestService_Rollback(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "claude_desktop_config.json")
+	if err := os.WriteFile(configPath, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{ConfigPath: configPath}
+	install(t, svc, 1)
+	installed, _ := os.ReadFile(configPath)
+	install(t, svc, 2)
+
+	// 新しいバックアップから順に戻る
+	for _, want := range []string{string(installed), original} {
+		res, err := svc.Rollback()
+		if err != nil {
+			t.Fatalf("Rollback() error = %v", err)
+		}
+		if res.ConfigPath != configPath {
+			t.Errorf("ConfigPath = %q, want %q", res.ConfigPath, configPath)
+		}
+		if _, err := os.Stat(res.Backup); !os.IsNotExist(err) {
+			t.Errorf("restored backup %s not removed", res.Backup)
+		}
+		got, _ := os.ReadFile(configPath)
+		if string(got) != want {
+			t.Errorf("config after Rollback() = %s, want %s", got, want)
+		}
+	}
+
+	if _, err := svc.Rollback(); !errors.Is(err, ErrNoBackup) {
+		t.Errorf("Rollback() without backups error = %v, want ErrNoBackup", err)
+	}
+}
+
+func TestService_Rollback_symlink(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "dotfiles", "claude_desktop_config.json")
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dest, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "claude_desktop_config.json")
+	if err := os.Symlink(dest, link); err != nil {
+		t.Skipf("symlink not supported: %v", err)
+	}
+
+	svc := &Service{ConfigPath: link}
+	install(t, svc, 1)
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("symlink replaced by Install: %v", err)
+	}
+	if _, err := svc.Rollback(); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != original {
+		t.Errorf("link target after Rollback() = %s, want original", got)
+	}
+}
+
+// install は n 番目の URL で svc.Install を呼びます。
+func install(t *testing.T, svc *Service, n int) {
+	t.Helper()
+	if err := svc.Install(fmt.Sprintf("http://localhost:%d/sse", 8080+n), "default", "/usr/local/bin/mcp-bridge"); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+}
+
+func mustListBackups(t *testing.T, path string) []string {
+	t.Helper()
+	backups, err := listBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backups
+}
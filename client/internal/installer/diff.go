@@ -0,0 +1,162 @@
+package installer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContext は unified diff で変更の前後に表示する変わらない行の数です。
+	diffContext = 3
+	// maxDiffCells は最長共通部分列を求める表の大きさの上限です。
+	// ~/.claude.json のように大きなファイルの大半が変わる場合に、メモリを使い過ぎないよう変わった範囲を丸ごと置き換えとして表示します。
+	maxDiffCells = 1 << 22
+)
+
+// diffOp は diff の 1 行です。kind は ' '（変更なし）、'-'（削除）、'+'（追加）のいずれかです。
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff は a から b への変更を、ファイル名を from / to とした unified diff で返します。a と b が同じ場合は空文字列を返します。
+func unifiedDiff(from, to string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", from, to)
+	// aLine / bLine は ops[i] より前にある a / b の行数
+	aLine, bLine := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// 変わらない行が 2*diffContext 行以下しか挟まらない変更は 1 つの hunk にまとめる
+		last := i
+		for j := i; j < len(ops) && j-last-1 <= 2*diffContext; j++ {
+			if ops[j].kind != ' ' {
+				last = j
+			}
+		}
+		start, end := max(0, i-diffContext), min(len(ops), last+diffContext+1)
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aLine[start], aLine[end]-aLine[start]), hunkRange(bLine[start], bLine[end]-bLine[start]))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange は hunk の見出しの範囲（開始行,行数）を返します。行数が 0 の場合は直前の行番号を開始行にします。
+func hunkRange(before, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, n)
+}
+
+// diffLines は a から b への行単位の編集を、最長共通部分列で求めます。
+func diffLines(a, b []string) []diffOp {
+	// 先頭と末尾の共通部分を除いて、表を小さくする
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	ops = append(ops, diffMiddle(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+// diffMiddle は a から b への編集を求めます。表が maxDiffCells を超える場合は a を全て削除して b を全て追加します。
+func diffMiddle(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	var ops []diffOp
+	if (n+1)*(m+1) > maxDiffCells {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return ops
+	}
+
+	// lcs[i][j] は a[i:] と b[j:] の最長共通部分列の長さ
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		default:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		}
+	}
+	return ops
+}
+
+// splitLines は data を改行を含めた行に分けます。最後の行が改行で終わらない場合はその行を改行なしで返します。
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
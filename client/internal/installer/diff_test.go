@@ -0,0 +1,127 @@
+package installer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "same",
+			a:    "{\n}\n",
+			b:    "{\n}\n",
+			want: "",
+		},
+		{
+			name: "new file",
+			b:    "{\n}",
+			want: `--- a
++++ b
+@@ -0,0 +1,2 @@
++{
++}
+\ No newline at end of file
+`,
+		},
+		{
+			name: "change with context",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: `--- a
++++ b
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`,
+		},
+		{
+			name: "changes 6 lines apart share a hunk",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\neight\n",
+			want: `--- a
++++ b
+@@ -1,8 +1,8 @@
+-1
++one
+ 2
+ 3
+ 4
+ 5
+ 6
+ 7
+-8
++eight
+`,
+		},
+		{
+			name: "changes 7 lines apart get separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\nnine\n",
+			want: `--- a
++++ b
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -6,4 +6,4 @@
+ 6
+ 7
+ 8
+-9
++nine
+`,
+		},
+		{
+			name: "trailing newline added",
+			a:    "{}",
+			b:    "{}\n",
+			want: `--- a
++++ b
+@@ -1 +1 @@
+-{}
+\ No newline at end of file
++{}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a []byte
+			if tt.a != "" {
+				a = []byte(tt.a)
+			}
+			if got := unifiedDiff("a", "b", a, []byte(tt.b)); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff_large(t *testing.T) {
+	// 表が maxDiffCells を超える場合は変わった範囲を丸ごと置き換えとして表示する
+	var a, b strings.Builder
+	for i := range 3000 {
+		a.WriteString("a" + strings.Repeat("x", i%7) + "\n")
+		b.WriteString("b" + strings.Repeat("x", i%7) + "\n")
+	}
+	got := unifiedDiff("a", "b", []byte(a.String()), []byte(b.String()))
+	if !strings.HasPrefix(got, "--- a\n+++ b\n@@ -1,3000 +1,3000 @@\n-a\n") {
+		t.Errorf("unifiedDiff() = %.60q...", got)
+	}
+	if n := strings.Count(got, "\n-"); n != 3000 {
+		t.Errorf("deleted lines = %d, want 3000", n)
+	}
+}
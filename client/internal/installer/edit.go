@@ -0,0 +1,333 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 設定ファイルは丸ごと書き直さず、vertex-ai-rag エントリの部分だけを元の内容に差し込みます。
+// ユーザーが書いたコメント、キーの並び、インデントはそのまま残ります。
+
+// defaultIndent は書式を推測できない場合のインデントの単位です。
+const defaultIndent = "  "
+
+// setServer は設定ファイルの内容 data の serversKey オブジェクトに、name のメンバーとして entry を追加または上書きした内容を返します。
+// serversKey が無い場合はトップレベルに追加し、オブジェクトでない場合は置き換えます。
+func setServer(data []byte, serversKey, name string, entry interface{}) ([]byte, error) {
+	src := stripJSONC(data)
+	root, err := parseObject(data, src, bytes.IndexByte(src, '{'))
+	if err != nil {
+		return nil, err
+	}
+
+	f := root.field(serversKey)
+	switch {
+	case f == nil:
+		return root.insert(serversKey, map[string]interface{}{name: entry})
+	case src[f.start] != '{':
+		return root.replace(f, map[string]interface{}{name: entry})
+	}
+	servers, err := parseObject(data, src, f.start)
+	if err != nil {
+		return nil, err
+	}
+	if f := servers.field(name); f != nil {
+		return servers.replace(f, entry)
+	}
+	return servers.insert(name, entry)
+}
+
+// removeServer は設定ファイルの内容 data の serversKey オブジェクトから name のメンバーを（重複していれば全て）削除した内容を返します。
+// 削除するメンバーが無い場合は removed に false を返します。
+func removeServer(data []byte, serversKey, name string) (out []byte, removed bool, err error) {
+	for {
+		src := stripJSONC(data)
+		root, err := parseObject(data, src, bytes.IndexByte(src, '{'))
+		if err != nil {
+			return nil, false, err
+		}
+		f := root.field(serversKey)
+		if f == nil || src[f.start] != '{' {
+			return data, removed, nil
+		}
+		servers, err := parseObject(data, src, f.start)
+		if err != nil {
+			return nil, false, err
+		}
+		if servers.field(name) == nil {
+			return data, removed, nil
+		}
+		data, removed = servers.remove(name), true
+	}
+}
+
+// object は設定ファイルの中の 1 つの JSON オブジェクトの位置です。
+type object struct {
+	// data は元の内容、src は data からコメントを取り除いたもの（stripJSONC は位置を変えないので、どちらにも同じ位置が使える）
+	data, src []byte
+	// open と close は { と } の位置
+	open, close int
+	fields      []field
+}
+
+// field はオブジェクトのメンバーの位置です。key はキーの先頭、[start, end) は値の範囲です。
+type field struct {
+	name       string
+	key        int
+	start, end int
+}
+
+// parseObject は設定ファイルの内容 data の open の位置から始まる JSON オブジェクトのメンバーの位置を求めます。
+// src は data からコメントを取り除いたもの（stripJSONC の戻り値）です。
+func parseObject(data, src []byte, open int) (*object, error) {
+	if open < 0 {
+		return nil, errors.New("設定ファイルのトップレベルが JSON オブジェクトではありません")
+	}
+	dec := json.NewDecoder(bytes.NewReader(src[open:]))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("設定ファイルの JSON 解析に失敗しました: %d バイト目がオブジェクトではありません", open)
+	}
+	obj := &object{data: data, src: src, open: open}
+	for dec.More() {
+		// InputOffset は直前の値の直後を指すので、空白とカンマを飛ばしてキーの先頭を求める
+		key := open + int(dec.InputOffset())
+		for key < len(src) && (isSpace(src[key]) || src[key] == ',') {
+			key++
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("設定ファイルの JSON 解析に失敗しました: %w", err)
+		}
+		name, _ := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("設定ファイルの JSON 解析に失敗しました: %w", err)
+		}
+		end := open + int(dec.InputOffset())
+		obj.fields = append(obj.fields, field{name: name, key: key, start: end - len(raw), end: end})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("設定ファイルの JSON 解析に失敗しました: %w", err)
+	}
+	obj.close = open + int(dec.InputOffset()) - 1
+	return obj, nil
+}
+
+// field は name のメンバーを返します。重複したキーは encoding/json と同じく最後のものを返します。無い場合は nil を返します。
+func (o *object) field(name string) *field {
+	for i := len(o.fields) - 1; i >= 0; i-- {
+		if o.fields[i].name == name {
+			return &o.fields[i]
+		}
+	}
+	return nil
+}
+
+// compact はメンバーが改行を挟まずに並んでいる（{"a":1,"b":2} のような）オブジェクトかどうかを返します。
+func (o *object) compact() bool {
+	return len(o.fields) > 0 && !bytes.Contains(o.data[o.open:o.fields[0].key], []byte("\n"))
+}
+
+// indent はメンバーの行のインデントと、1 段分のインデントを返します。
+func (o *object) indent() (member, unit string) {
+	parent := lineIndent(o.data, o.open)
+	if len(o.fields) > 0 {
+		if ind, ok := leadingIndent(o.data, o.fields[0].key); ok && len(ind) > len(parent) && strings.HasPrefix(ind, parent) {
+			return ind, ind[len(parent):]
+		}
+	}
+	return parent + defaultIndent, defaultIndent
+}
+
+// format は val をオブジェクトの書式に合わせた JSON にします。prefix は値の 2 行目以降の先頭に付けるインデントです。
+func (o *object) format(val interface{}, prefix string) ([]byte, error) {
+	var (
+		out []byte
+		err error
+	)
+	if o.compact() {
+		out, err = json.Marshal(val)
+	} else {
+		_, unit := o.indent()
+		out, err = json.MarshalIndent(val, prefix, unit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("設定の JSON 出力に失敗しました: %w", err)
+	}
+	return out, nil
+}
+
+// replace は data の f の値を val に置き換えた内容を返します。
+func (o *object) replace(f *field, val interface{}) ([]byte, error) {
+	prefix, ok := leadingIndent(o.data, f.key)
+	if !ok {
+		prefix, _ = o.indent()
+	}
+	v, err := o.format(val, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return applySplices(o.data, splice{f.start, f.end, string(v)}), nil
+}
+
+// insert は data のオブジェクトの末尾に name のメンバーとして val を追加した内容を返します。
+func (o *object) insert(name string, val interface{}) ([]byte, error) {
+	key, _ := json.Marshal(name)
+	member, _ := o.indent()
+	v, err := o.format(val, member)
+	if err != nil {
+		return nil, err
+	}
+
+	// 末尾カンマ（JSONC）のあるオブジェクトでは、追加するメンバーにも末尾カンマを付けて書式を揃える
+	trailing := o.trailingComma()
+	if o.compact() {
+		text := string(key) + ":" + string(v)
+		if trailing >= 0 {
+			return applySplices(o.data, splice{trailing + 1, trailing + 1, text + ","}), nil
+		}
+		return applySplices(o.data, splice{o.close, o.close, "," + text}), nil
+	}
+
+	var splices []splice
+	text := "\n" + member + string(key) + ": " + string(v)
+	switch {
+	case trailing >= 0:
+		text += ","
+	case len(o.fields) > 0:
+		last := o.fields[len(o.fields)-1]
+		splices = append(splices, splice{last.end, last.end, ","})
+	}
+	// 最後のメンバー（とその行のコメント）の後ろ、} の前の改行とインデントの手前に追加する
+	at := o.close
+	for at > o.open+1 && isSpace(o.data[at-1]) {
+		at--
+	}
+	if !bytes.Contains(o.data[at:o.close], []byte("\n")) {
+		text += "\n" + lineIndent(o.data, o.open)
+	}
+	splices = append(splices, splice{at, at, text})
+	return applySplices(o.data, splices...), nil
+}
+
+// remove は data のオブジェクトから name のメンバーを、区切りのカンマとともに削除した内容を返します。
+// メンバーの前後のコメントは残します。
+func (o *object) remove(name string) []byte {
+	i := len(o.fields) - 1
+	for o.fields[i].name != name {
+		i--
+	}
+	f := o.fields[i]
+	start, end := f.key, f.end
+
+	var splices []splice
+	next := o.close
+	if i+1 < len(o.fields) {
+		next = o.fields[i+1].key
+	}
+	if c := commaAfter(o.data, f.end, next); c >= 0 {
+		if len(bytes.Trim(o.data[f.end:c], " \t")) == 0 {
+			end = c + 1
+		} else {
+			splices = append(splices, splice{c, c + 1, ""})
+		}
+	} else if i > 0 {
+		// 最後のメンバーを削除する場合は、前のメンバーとの間のカンマを削除する
+		c := f.key - 1
+		for isSpace(o.src[c]) {
+			c--
+		}
+		splices = append(splices, splice{c, c + 1, ""})
+	}
+
+	// メンバーだけの行は行ごと削除する
+	rest := end
+	for rest < len(o.data) && (o.data[rest] == ' ' || o.data[rest] == '\t' || o.data[rest] == '\r') {
+		rest++
+	}
+	if _, ok := leadingIndent(o.data, f.key); ok && rest < len(o.data) && o.data[rest] == '\n' {
+		start, end = lineStart(o.data, f.key), rest+1
+	}
+	splices = append(splices, splice{start, end, ""})
+	return applySplices(o.data, splices...)
+}
+
+// trailingComma は最後のメンバーの後ろ、} の前にある末尾カンマ（JSONC）の位置を返します。無い場合は -1 を返します。
+func (o *object) trailingComma() int {
+	if len(o.fields) == 0 {
+		return -1
+	}
+	return commaAfter(o.data, o.fields[len(o.fields)-1].end, o.close)
+}
+
+// commaAfter は data[from:to] で空白とコメントを飛ばした最初の文字がカンマならその位置を、そうでなければ -1 を返します。
+func commaAfter(data []byte, from, to int) int {
+	for i := from; i < to; i++ {
+		switch {
+		case isSpace(data[i]):
+		case data[i] == ',':
+			return i
+		case bytes.HasPrefix(data[i:], []byte("//")):
+			for i < to && data[i] != '\n' {
+				i++
+			}
+		case bytes.HasPrefix(data[i:], []byte("/*")):
+			end := bytes.Index(data[i+2:to], []byte("*/"))
+			if end < 0 {
+				return -1
+			}
+			i += 2 + end + 1
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// splice は元の内容の [start, end) を text に置き換える変更です。
+type splice struct {
+	start, end int
+	text       string
+}
+
+// applySplices は data に重ならない splices をまとめて適用した内容を返します。
+func applySplices(data []byte, splices ...splice) []byte {
+	sort.Slice(splices, func(i, j int) bool { return splices[i].start < splices[j].start })
+	out := make([]byte, 0, len(data))
+	pos := 0
+	for _, s := range splices {
+		out = append(out, data[pos:s.start]...)
+		out = append(out, s.text...)
+		pos = s.end
+	}
+	return append(out, data[pos:]...)
+}
+
+// lineStart は data の i を含む行の先頭の位置を返します。
+func lineStart(data []byte, i int) int {
+	return bytes.LastIndexByte(data[:i], '\n') + 1
+}
+
+// lineIndent は data の i を含む行の先頭にある空白を返します。
+func lineIndent(data []byte, i int) string {
+	start := lineStart(data, i)
+	end := start
+	for end < i && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+// leadingIndent は data の i が行の中で空白以外の最初の文字であれば、その前の空白と true を返します。
+func leadingIndent(data []byte, i int) (string, bool) {
+	ind := lineIndent(data, i)
+	return ind, lineStart(data, i)+len(ind) == i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
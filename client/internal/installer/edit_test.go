@@ -0,0 +1,170 @@
+package installer
+
+import (
+	"testing"
+)
+
+func TestSetServer(t *testing.T) {
+	entry := map[string]interface{}{"command": "bridge"}
+	tests := []struct {
+		name string
+		key  string // 空の場合は mcpServers
+		in   string
+		want string
+		// roundTrip は want から removeServer で削除すると in に戻ることを確認するかどうか
+		roundTrip bool
+	}{
+		{
+			name:      "compact",
+			in:        `{"mcpServers":{"other":{"command":"x"}}}`,
+			want:      `{"mcpServers":{"other":{"command":"x"},"vertex-ai-rag":{"command":"bridge"}}}`,
+			roundTrip: true,
+		},
+		{
+			name: "indented with comments",
+			in: `// settings
+{
+    "theme": "dark", // keep
+    "mcpServers": {
+        /* other server */
+        "other": {"command": "x"} // trailing
+    }
+}
+`,
+			want: `// settings
+{
+    "theme": "dark", // keep
+    "mcpServers": {
+        /* other server */
+        "other": {"command": "x"}, // trailing
+        "vertex-ai-rag": {
+            "command": "bridge"
+        }
+    }
+}
+`,
+			roundTrip: true,
+		},
+		{
+			name:      "trailing commas",
+			key:       "context_servers",
+			in:        "{\n  \"context_servers\": {\n    \"other\": {},\n  },\n}\n",
+			want:      "{\n  \"context_servers\": {\n    \"other\": {},\n    \"vertex-ai-rag\": {\n      \"command\": \"bridge\"\n    },\n  },\n}\n",
+			roundTrip: true,
+		},
+		{
+			name:      "compact with trailing comma",
+			in:        `{"mcpServers": {"other": {},}}`,
+			want:      `{"mcpServers": {"other": {},"vertex-ai-rag":{"command":"bridge"},}}`,
+			roundTrip: true,
+		},
+		{
+			name: "no servers key",
+			in:   "{\n\t\"theme\": \"dark\"\n}\n",
+			want: "{\n\t\"theme\": \"dark\",\n\t\"mcpServers\": {\n\t\t\"vertex-ai-rag\": {\n\t\t\t\"command\": \"bridge\"\n\t\t}\n\t}\n}\n",
+		},
+		{
+			name: "empty servers",
+			in:   "{\n  \"mcpServers\": {}\n}",
+			want: "{\n  \"mcpServers\": {\n    \"vertex-ai-rag\": {\n      \"command\": \"bridge\"\n    }\n  }\n}",
+		},
+		{
+			name: "empty root",
+			in:   "{}",
+			want: "{\n  \"mcpServers\": {\n    \"vertex-ai-rag\": {\n      \"command\": \"bridge\"\n    }\n  }\n}",
+		},
+		{
+			name: "replace existing entry",
+			in:   "{\n  \"mcpServers\": {\n    \"vertex-ai-rag\": {\"command\": \"old\"}, // ours\n    \"other\": {}\n  }\n}",
+			want: "{\n  \"mcpServers\": {\n    \"vertex-ai-rag\": {\n      \"command\": \"bridge\"\n    }, // ours\n    \"other\": {}\n  }\n}",
+		},
+		{
+			name: "servers not an object",
+			in:   `{"mcpServers": null}`,
+			want: `{"mcpServers": {"vertex-ai-rag":{"command":"bridge"}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key
+			if key == "" {
+				key = "mcpServers"
+			}
+			got, err := setServer([]byte(tt.in), key, ServerKey, entry)
+			if err != nil {
+				t.Fatalf("setServer() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("setServer() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if !tt.roundTrip {
+				return
+			}
+			back, removed, err := removeServer(got, key, ServerKey)
+			if err != nil || !removed {
+				t.Fatalf("removeServer() = %v, %v", removed, err)
+			}
+			if string(back) != tt.in {
+				t.Errorf("removeServer() =\n%s\nwant\n%s", back, tt.in)
+			}
+		})
+	}
+}
+
+func TestRemoveServer(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		want        string
+		wantRemoved bool
+	}{
+		{
+			name:        "first of several",
+			in:          "{\n  \"mcpServers\": {\n    // ours\n    \"vertex-ai-rag\": {\n      \"command\": \"old\"\n    },\n    \"other\": {} // theirs\n  }\n}\n",
+			want:        "{\n  \"mcpServers\": {\n    // ours\n    \"other\": {} // theirs\n  }\n}\n",
+			wantRemoved: true,
+		},
+		{
+			name:        "last of several",
+			in:          "{\n  \"mcpServers\": {\n    \"other\": {}, // theirs\n    \"vertex-ai-rag\": {}\n  }\n}\n",
+			want:        "{\n  \"mcpServers\": {\n    \"other\": {} // theirs\n  }\n}\n",
+			wantRemoved: true,
+		},
+		{
+			name:        "only member compact",
+			in:          `{"mcpServers":{"vertex-ai-rag":{"command":"old"}},"n":1}`,
+			want:        `{"mcpServers":{},"n":1}`,
+			wantRemoved: true,
+		},
+		{
+			name:        "duplicate keys",
+			in:          `{"mcpServers":{"vertex-ai-rag":1,"vertex-ai-rag":2}}`,
+			want:        `{"mcpServers":{}}`,
+			wantRemoved: true,
+		},
+		{
+			name: "not registered",
+			in:   `{"mcpServers": {"other": {}}} // x`,
+			want: `{"mcpServers": {"other": {}}} // x`,
+		},
+		{
+			name: "servers not an object",
+			in:   `{"mcpServers": []}`,
+			want: `{"mcpServers": []}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed, err := removeServer([]byte(tt.in), "mcpServers", ServerKey)
+			if err != nil {
+				t.Fatalf("removeServer() error = %v", err)
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			if string(got) != tt.want {
+				t.Errorf("removeServer() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package installer
+
+// stripJSONC は JSON with Comments（Zed や VS Code の設定ファイルの形式）から、
+// 文字列の外にある // と /* */ のコメント、および } や ] の直前の末尾カンマを取り除いた JSON を返します。
+// コメントと末尾カンマは同じ長さの空白に置き換える（改行は残す）ので、戻り値の位置は元のファイルの位置とそのまま一致します。
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	// comma は出力済みで、後に値が続くか未確定のカンマの位置（無い場合は -1）
+	comma := -1
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(data) && data[end] != '"' {
+				if data[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(data))
+			out = append(out, data[i:end]...)
+			i = end - 1
+			comma = -1
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				out = append(out, ' ')
+				i++
+			}
+			i--
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := i + 2
+			for end+1 < len(data) && !(data[end] == '*' && data[end+1] == '/') {
+				end++
+			}
+			end = min(end+2, len(data))
+			for _, b := range data[i:end] {
+				if b != '\n' {
+					b = ' '
+				}
+				out = append(out, b)
+			}
+			i = end - 1
+		case c == ',':
+			comma = len(out)
+			out = append(out, c)
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			out = append(out, c)
+			comma = -1
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			out = append(out, c)
+		default:
+			out = append(out, c)
+			comma = -1
+		}
+	}
+	return out
+}
@@ -0,0 +1,66 @@
+package installer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // 比較は JSON として解釈した値で行う
+	}{
+		{
+			name: "plain JSON",
+			in:   `{"a":1,"b":[1,2]}`,
+			want: `{"a":1,"b":[1,2]}`,
+		},
+		{
+			name: "line and block comments",
+			in: `// Zed settings
+{
+  /* theme */ "theme": "dark", // trailing
+  "n": 1
+}`,
+			want: `{"theme":"dark","n":1}`,
+		},
+		{
+			name: "trailing commas",
+			in:   `{"a": [1, 2, ], "b": {"c": 1,},}`,
+			want: `{"a":[1,2],"b":{"c":1}}`,
+		},
+		{
+			name: "comment between trailing comma and brace",
+			in:   "{\"a\": 1, // last\n}",
+			want: `{"a":1}`,
+		},
+		{
+			name: "comment markers and commas inside strings",
+			in:   `{"url": "http://example.com/*x*/", "s": "a,}", "q": "say \"//hi\""}`,
+			want: `{"url":"http://example.com/*x*/","s":"a,}","q":"say \"//hi\""}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 位置を変えないので、長さと改行の位置は元のまま
+			out := stripJSONC([]byte(tt.in))
+			if len(out) != len(tt.in) || strings.Count(string(out), "\n") != strings.Count(tt.in, "\n") {
+				t.Errorf("stripJSONC() = %q, want the same length and lines as %q", out, tt.in)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatalf("stripJSONC() = %s: %v", out, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("stripJSONC() = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// configFileName は Claude Desktop の設定ファイル名です。
+const configFileName = "claude_desktop_config.json"
+
+// platform は設定ファイルのパスの解決に使う OS と環境です。テストでは GOOS や環境変数を差し替えます。
+type platform struct {
+	goos    string
+	getenv  func(string) string
+	homeDir func() (string, error)
+	// glob は filepath.Glob と同じく pattern に一致するパスを返す
+	glob func(pattern string) ([]string, error)
+	// isDir は path がディレクトリとして存在するかを返す
+	isDir func(path string) bool
+}
+
+// hostPlatform は実行中の OS と環境を返します。
+func hostPlatform() platform {
+	return platform{
+		goos:    runtime.GOOS,
+		getenv:  os.Getenv,
+		homeDir: os.UserHomeDir,
+		glob:    filepath.Glob,
+		isDir: func(path string) bool {
+			fi, err := os.Stat(path)
+			return err == nil && fi.IsDir()
+		},
+	}
+}
+
+// ConfigPathByOS は runtime.GOOS に応じた Claude Desktop 設定ファイルのパスを返します。
+// - windows: %APPDATA%\Claude\claude_desktop_config.json
+// - darwin (macOS): ~/Library/Application Support/Claude/claude_desktop_config.json
+// - linux: 次の順に探し、Claude の設定ディレクトリが見つかった最初の場所
+//   - $XDG_CONFIG_HOME/Claude/claude_desktop_config.json（XDG_CONFIG_HOME が未設定なら ~/.config）
+//   - Flatpak: ~/.var/app/<アプリ ID>/config/Claude/claude_desktop_config.json
+//   - Snap: ~/snap/<スナップ名>/current/.config/Claude/claude_desktop_config.json
+//
+// windows と darwin ではファイルが存在しない場合でもパスを返します。呼び出し側で新規作成してください。
+// linux では Claude Desktop のインストール方法によって場所が変わるため、設定ディレクトリが見つからない場合は推測せずにエラーを返します。
+// Windows で APPDATA が未設定の場合や、上記以外の OS でもエラーを返します。
+func ConfigPathByOS() (string, error) {
+	return configPath(hostPlatform())
+}
+
+func configPath(p platform) (string, error) {
+	switch p.goos {
+	case "windows", "darwin":
+		dir, err := userConfigDir(p)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "Claude", configFileName), nil
+	case "linux":
+		return linuxConfigPath(p)
+	default:
+		return "", fmt.Errorf("%s では Claude Desktop 設定のパスを特定できません。--config で設定ファイルのパスを指定してください", p.goos)
+	}
+}
+
+// userConfigDir は os.UserConfigDir と同じく OS のユーザー設定ディレクトリを返します。
+// - windows: %APPDATA%
+// - darwin (macOS): ~/Library/Application Support
+// - それ以外: $XDG_CONFIG_HOME（未設定なら ~/.config）
+func userConfigDir(p platform) (string, error) {
+	switch p.goos {
+	case "windows":
+		appdata := p.getenv("APPDATA")
+		if appdata == "" {
+			return "", fmt.Errorf("APPDATA が設定されていません。Windows では設定ファイルのパスを特定できません")
+		}
+		return appdata, nil
+	case "darwin":
+		home, err := p.homeDir()
+		if err != nil {
+			return "", fmt.Errorf("ホームディレクトリの取得に失敗しました: %w", err)
+		}
+		return filepath.Join(home, "Library", "Application Support"), nil
+	default:
+		return xdgConfigHome(p)
+	}
+}
+
+// xdgConfigHome は $XDG_CONFIG_HOME を返します。未設定または相対パスの場合は ~/.config を返します。
+func xdgConfigHome(p platform) (string, error) {
+	if dir := p.getenv("XDG_CONFIG_HOME"); dir != "" && filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	// XDG Base Directory の仕様では相対パスの XDG_CONFIG_HOME は無効として扱う
+	home, err := p.homeDir()
+	if err != nil {
+		return "", fmt.Errorf("ホームディレクトリの取得に失敗しました: %w", err)
+	}
+	return filepath.Join(home, ".config"), nil
+}
+
+// linuxConfigPath は Linux での Claude Desktop の設定ファイルを、通常のインストール・Flatpak・Snap の順に探します。
+func linuxConfigPath(p platform) (string, error) {
+	home, err := p.homeDir()
+	if err != nil {
+		return "", fmt.Errorf("ホームディレクトリの取得に失敗しました: %w", err)
+	}
+
+	configHome, err := xdgConfigHome(p)
+	if err != nil {
+		return "", err
+	}
+	dirs := []string{filepath.Join(configHome, "Claude")}
+
+	// サンドボックス化されたインストールはアプリ ID やスナップ名が配布元によって異なるので、Claude の設定ディレクトリを持つものを探す
+	for _, pattern := range []string{
+		filepath.Join(home, ".var", "app", "*", "config", "Claude"),
+		filepath.Join(home, "snap", "*", "current", ".config", "Claude"),
+	} {
+		matches, err := p.glob(pattern)
+		if err != nil {
+			return "", fmt.Errorf("Claude Desktop のインストール先の検索に失敗しました: %w", err)
+		}
+		sort.Strings(matches)
+		dirs = append(dirs, matches...)
+	}
+
+	for _, dir := range dirs {
+		if p.isDir(dir) {
+			return filepath.Join(dir, configFileName), nil
+		}
+	}
+	return "", &NotFoundError{Searched: []string{
+		filepath.Join(configHome, "Claude"),
+		filepath.Join(home, ".var", "app", "*", "config", "Claude"),
+		filepath.Join(home, "snap", "*", "current", ".config", "Claude"),
+	}}
+}
+
+// NotFoundError は Claude Desktop のインストール（設定ディレクトリ）が見つからなかったことを表します。
+type NotFoundError struct {
+	// Searched は探した設定ディレクトリ（ワイルドカードを含む）
+	Searched []string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Claude Desktop の設定ディレクトリが見つかりません（確認した場所: %s）。"+
+		"Claude Desktop を一度起動してから再実行するか、--config で設定ファイルのパスを指定してください", strings.Join(e.Searched, ", "))
+}
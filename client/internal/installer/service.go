@@ -1,123 +1,261 @@
 package installer
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"runtime"
 )
 
 const (
-	// ServerKey は claude_desktop_config.json の mcpServers に追加するキー名です。
+	// ServerKey は MCP クライアントの設定ファイルの mcpServers（クライアントによっては servers など）に追加するキー名です。
 	ServerKey = "vertex-ai-rag"
+	// ProfileEnv はエントリの env に設定する、connect にプロファイル名を渡す環境変数です。
+	ProfileEnv = "MCP_BRIDGE_PROFILE"
 )
 
-// Service は Claude Desktop 設定ファイルの更新を行います。
+// Service は MCP クライアントの設定ファイルの更新を行います。
 type Service struct {
 	// ConfigPath は書き換え対象の設定ファイルの絶対パス。
-	// 空の場合は ConfigPathByOS() で決定したパスを使用する。
+	// 空の場合は Target の ConfigPath() で決定したパスを使用する。
 	ConfigPath string
+	// Target は設定を書き換える MCP クライアント。nil の場合は Claude Desktop。
+	Target Target
+	// KeepBackups は設定ファイルごとに残すバックアップの数。0 以下の場合は DefaultKeepBackups。
+	KeepBackups int
+
+	// write は一時ファイルへの書き込み（テストで途中での失敗を再現するために差し替える）。nil の場合は w.Write。
+	write func(w io.Writer, data []byte) error
 }
 
-// ConfigPathByOS は runtime.GOOS に応じた Claude Desktop 設定ファイルのパスを返します。
-// ファイルが存在しない場合でもパスは返します。呼び出し側で新規作成してください。
-// - windows: %APPDATA%\Claude\claude_desktop_config.json
-// - darwin (macOS): ~/Library/Application Support/Claude/claude_desktop_config.json
-// 上記以外の OS では darwin と同じパスを返します。
-// Windows で APPDATA が未設定の場合はエラーを返します。
-func ConfigPathByOS() (string, error) {
-	switch runtime.GOOS {
-	case "windows":
-		appdata := os.Getenv("APPDATA")
-		if appdata == "" {
-			return "", fmt.Errorf("APPDATA が設定されていません。Windows では Claude Desktop 設定のパスを特定できません")
-		}
-		return filepath.Join(appdata, "Claude", "claude_desktop_config.json"), nil
-	default:
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", fmt.Errorf("ホームディレクトリの取得に失敗しました: %w", err)
-		}
-		return filepath.Join(home, "Library", "Application Support", "Claude", "claude_desktop_config.json"), nil
+// target は s.Target を返します。未設定の場合は Claude Desktop を返します。
+func (s *Service) target() Target {
+	if s.Target != nil {
+		return s.Target
 	}
+	return targets(hostPlatform())[0]
 }
 
-// Install は設定ファイルを読み込み、mcpServers に vertex-ai-rag エントリを追加または上書きして保存します。
-// serverURL は MCP サーバーの URL（例: http://localhost:8080/sse）、profile は AWS プロファイル名です。
+// configPath は書き換え対象の設定ファイルのパスを返します。
+func (s *Service) configPath() (string, error) {
+	if s.ConfigPath != "" {
+		return s.ConfigPath, nil
+	}
+	return s.target().ConfigPath()
+}
+
+// Install は設定ファイルを読み込み、Target の mcpServers などに vertex-ai-rag エントリを追加または上書きして保存します。
+// 既存の設定ファイルはエントリの部分だけを書き換え、他の内容やコメント、書式はそのまま残します。
+// serverURL は MCP サーバーの URL（例: http://localhost:8080/sse）、profile は connect が使うプロファイル名です。
 // binaryPath は command に設定する mcp-bridge バイナリの絶対パス（通常は os.Executable() の戻り値）です。
+// PlanInstall で作った変更を Apply で書き込むのと同じです。
 func (s *Service) Install(serverURL, profile, binaryPath string) error {
-	configPath := s.ConfigPath
-	if configPath == "" {
-		p, err := ConfigPathByOS()
-		if err != nil {
-			return err
-		}
-		configPath = p
+	plan, err := s.PlanInstall(serverURL, profile, binaryPath)
+	if err != nil {
+		return err
 	}
+	return s.Apply(plan)
+}
 
-	dir := filepath.Dir(configPath)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return fmt.Errorf("設定ディレクトリの作成に失敗しました (%s): %w", dir, err)
+// PlanAction は InstallPlan で設定ファイルに行う操作です。
+type PlanAction string
+
+const (
+	// ActionCreate は設定ファイルを新規作成することを表します。
+	ActionCreate PlanAction = "create"
+	// ActionUpdate は既存の設定ファイルを書き換えることを表します。
+	ActionUpdate PlanAction = "update"
+	// ActionUnchanged は既に同じ内容で登録済みで、設定ファイルを書き換えないことを表します。
+	ActionUnchanged PlanAction = "unchanged"
+)
+
+// InstallPlan は Install で設定ファイルに加える変更です。JSON にしてオーケストレーションツールに渡せます。
+type InstallPlan struct {
+	// Target は MCP クライアントの名前（--target で指定する名前）
+	Target string `json:"target"`
+	// ConfigPath は書き換える設定ファイルのパス
+	ConfigPath string     `json:"configPath"`
+	Action     PlanAction `json:"action"`
+	// ServersKey は vertex-ai-rag エントリを置くキー（mcpServers など）
+	ServersKey string `json:"serversKey"`
+	ServerKey  string `json:"serverKey"`
+	// Entry は登録するエントリ
+	Entry map[string]interface{} `json:"entry"`
+	// PreviousEntry は上書きされる既存のエントリ（無い場合は nil）
+	PreviousEntry interface{} `json:"previousEntry,omitempty"`
+	// Diff は書き換え前と書き換え後の設定ファイルの unified diff（変更が無い場合は空）
+	Diff string `json:"diff"`
+
+	// after は書き換え後の設定ファイルの内容
+	after []byte
+}
+
+// PlanInstall は Install と同じく設定ファイルに vertex-ai-rag エントリを追加または上書きした結果を作りますが、書き込みはしません。
+// 引数は Install と同じです。
+func (s *Service) PlanInstall(serverURL, profile, binaryPath string) (*InstallPlan, error) {
+	configPath, err := s.configPath()
+	if err != nil {
+		return nil, err
 	}
 
-	root, err := s.readConfig(configPath)
+	root, before, err := s.readConfig(configPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	mcpServers, _ := root["mcpServers"].(map[string]interface{})
-	if mcpServers == nil {
-		mcpServers = make(map[string]interface{})
-		root["mcpServers"] = mcpServers
+	t := s.target()
+	servers, _ := root[t.ServersKey()].(map[string]interface{})
+	plan := &InstallPlan{
+		Target:        t.Name(),
+		ConfigPath:    configPath,
+		ServersKey:    t.ServersKey(),
+		ServerKey:     ServerKey,
+		PreviousEntry: servers[ServerKey],
+		// connect は MCP_BRIDGE_PROFILE 環境変数でプロファイル（認証設定とトークン）を選ぶ
+		Entry: t.Entry(binaryPath, []string{"connect", "--url", serverURL}, map[string]string{
+			ProfileEnv: profile,
+		}),
 	}
 
-	mcpServers[ServerKey] = map[string]interface{}{
-		"command": binaryPath,
-		"args":    []interface{}{"connect", "--url", serverURL},
-		"env": map[string]interface{}{
-			"AWS_PROFILE": profile,
-		},
+	if len(before) == 0 {
+		plan.after, err = marshalConfig(map[string]interface{}{
+			t.ServersKey(): map[string]interface{}{ServerKey: plan.Entry},
+		})
+	} else {
+		// 既存の設定ファイルはエントリの部分だけを書き換え、コメントや書式を残す
+		plan.after, err = setServer(before, t.ServersKey(), ServerKey, plan.Entry)
 	}
+	if err != nil {
+		return nil, err
+	}
+	from := configPath
+	switch {
+	case before == nil:
+		plan.Action = ActionCreate
+		from = "/dev/null"
+	case bytes.Equal(before, plan.after):
+		plan.Action = ActionUnchanged
+	default:
+		plan.Action = ActionUpdate
+	}
+	plan.Diff = unifiedDiff(from, configPath, before, plan.after)
+	return plan, nil
+}
 
-	if err := s.writeConfig(configPath, root); err != nil {
-		return err
+// Apply は PlanInstall で作った plan を設定ファイルに書き込みます。設定ファイルやディレクトリが無い場合は作成します。
+func (s *Service) Apply(plan *InstallPlan) error {
+	if plan.Action == ActionUnchanged {
+		return nil
 	}
+	dir := filepath.Dir(plan.ConfigPath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("設定ディレクトリの作成に失敗しました (%s): %w", dir, err)
+	}
+	return s.writeConfig(plan.ConfigPath, plan.after)
+}
 
-	return nil
+// UninstallResult は Uninstall で行った変更です。
+type UninstallResult struct {
+	// ConfigPath は対象の設定ファイルのパス。設定ファイルの場所が見つからなかった場合は空。
+	ConfigPath string
+	// Removed は mcpServers など（Target の ServersKey）から vertex-ai-rag エントリを削除したかどうか。
+	// 既に登録されていなかった場合は false で、設定ファイルは書き換えない。
+	Removed bool
+}
+
+// Uninstall は設定ファイルの mcpServers など（Target の ServersKey）から vertex-ai-rag エントリを削除して保存します。
+// 他のサーバーやトップレベルのキー、コメントや書式はそのまま残し、mcpServers が空になってもキー自体は削除しません。
+// 設定ファイルやエントリが存在しない場合は何もせずに成功します。
+func (s *Service) Uninstall() (*UninstallResult, error) {
+	configPath, err := s.configPath()
+	var nf *NotFoundError
+	if errors.As(err, &nf) {
+		// クライアントの設定ディレクトリが無い = 登録されていない
+		return &UninstallResult{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &UninstallResult{ConfigPath: configPath}
+
+	_, before, err := s.readConfig(configPath)
+	if err != nil || len(before) == 0 {
+		return res, err
+	}
+	data, removed, err := removeServer(before, s.target().ServersKey(), ServerKey)
+	if err != nil || !removed {
+		return res, err
+	}
+	if err := s.writeConfig(configPath, data); err != nil {
+		return nil, err
+	}
+	res.Removed = true
+	return res, nil
 }
 
-func (s *Service) readConfig(path string) (map[string]interface{}, error) {
+// readConfig は設定ファイルを読み込み、解析した内容と元のファイルの内容を返します。
+// ファイルが無い場合は空の内容と nil を返します。
+func (s *Service) readConfig(path string) (map[string]interface{}, []byte, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return make(map[string]interface{}), nil
+			return make(map[string]interface{}), nil, nil
 		}
 		if os.IsPermission(err) {
-			return nil, fmt.Errorf("設定ファイルの読み取り権限がありません: %s", path)
+			return nil, nil, fmt.Errorf("設定ファイルの読み取り権限がありません: %s", path)
 		}
-		return nil, fmt.Errorf("設定ファイルの読み取りに失敗しました: %w", err)
+		return nil, nil, fmt.Errorf("設定ファイルの読み取りに失敗しました: %w", err)
 	}
 
 	var root map[string]interface{}
 	if len(data) == 0 {
-		return make(map[string]interface{}), nil
+		return make(map[string]interface{}), data, nil
 	}
-	if err := json.Unmarshal(data, &root); err != nil {
-		return nil, fmt.Errorf("設定ファイルの JSON 解析に失敗しました: %w", err)
+	// 既存のエントリ（PreviousEntry）の数値を float64 に丸めて表示しないよう、json.Number のまま保持する。
+	// Zed や VS Code の設定ファイルはコメントと末尾カンマを許すので取り除いてから解析する。
+	dec := json.NewDecoder(bytes.NewReader(stripJSONC(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&root); err != nil {
+		return nil, nil, fmt.Errorf("設定ファイルの JSON 解析に失敗しました: %w", err)
 	}
 	if root == nil {
 		root = make(map[string]interface{})
 	}
-	return root, nil
+	return root, data, nil
 }
 
-func (s *Service) writeConfig(path string, root map[string]interface{}) error {
+// marshalConfig は設定ファイルに書き込む root の JSON を返します。
+func marshalConfig(root map[string]interface{}) ([]byte, error) {
 	data, err := json.MarshalIndent(root, "", "  ")
 	if err != nil {
-		return fmt.Errorf("設定の JSON 出力に失敗しました: %w", err)
+		return nil, fmt.Errorf("設定の JSON 出力に失敗しました: %w", err)
+	}
+	return data, nil
+}
+
+// writeConfig は data を path に書き込みます。内容が変わらない場合は何もしません。
+// 書き込み前に元のファイルをバックアップし、一時ファイルへ書き込んでから rename で置き換えるので、
+// 書き込みの途中で失敗したりプロセスが落ちたりしても元のファイルは壊れません。
+func (s *Service) writeConfig(path string, data []byte) error {
+	path = realPath(path)
+
+	old, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if bytes.Equal(old, data) {
+			return nil
+		}
+		if err := s.backup(path, old); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("設定ファイルの読み取りに失敗しました: %w", err)
 	}
-	if err := os.WriteFile(path, data, 0600); err != nil {
+
+	if err := s.writeFileAtomic(path, data); err != nil {
 		if os.IsPermission(err) {
 			return fmt.Errorf("設定ファイルの書き込み権限がありません: %s", path)
 		}
@@ -125,3 +263,54 @@ func (s *Service) writeConfig(path string, root map[string]interface{}) error {
 	}
 	return nil
 }
+
+// writeFileAtomic は path と同じディレクトリの一時ファイルに data を書き込み、rename で path を置き換えます。
+// 既存のファイルのパーミッションは引き継ぎ、新規作成の場合は 0600 にします。失敗した場合は一時ファイルを削除します。
+func (s *Service) writeFileAtomic(path string, data []byte) (err error) {
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	write := s.write
+	if write == nil {
+		write = func(w io.Writer, data []byte) error {
+			_, err := w.Write(data)
+			return err
+		}
+	}
+	if err := write(f, data); err != nil {
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+	// rename の後に電源が落ちても中身が空のファイルにならないよう、置き換える前にディスクへ書き出す
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// realPath は path がシンボリックリンクの場合にリンク先のパスを返します。
+// dotfiles の管理などでリンクにしている設定ファイルを、rename で通常のファイルに置き換えてしまわないようにします。
+func realPath(path string) string {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		return p
+	}
+	return path
+}
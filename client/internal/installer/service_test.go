@@ -2,9 +2,9 @@ package installer
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"testing"
 )
@@ -33,7 +33,7 @@ func TestService_Install(t *testing.T) {
 			wantKey:  ServerKey,
 			wantCmd:  binaryPath,
 			wantArgs: []interface{}{"connect", "--url", "http://localhost:8080/sse"},
-			wantEnv:  map[string]interface{}{"AWS_PROFILE": "default"},
+			wantEnv:  map[string]interface{}{"MCP_BRIDGE_PROFILE": "default"},
 		},
 		{
 			name:     "existing mcpServers merged",
@@ -43,7 +43,7 @@ func TestService_Install(t *testing.T) {
 			wantKey:  ServerKey,
 			wantCmd:  binaryPath,
 			wantArgs: []interface{}{"connect", "--url", "http://example.com/sse"},
-			wantEnv:  map[string]interface{}{"AWS_PROFILE": "myprofile"},
+			wantEnv:  map[string]interface{}{"MCP_BRIDGE_PROFILE": "myprofile"},
 			preserve: "other",
 		},
 		{
@@ -54,7 +54,7 @@ func TestService_Install(t *testing.T) {
 			wantKey:  ServerKey,
 			wantCmd:  binaryPath,
 			wantArgs: []interface{}{"connect", "--url", "http://new:9090/sse"},
-			wantEnv:  map[string]interface{}{"AWS_PROFILE": "default"},
+			wantEnv:  map[string]interface{}{"MCP_BRIDGE_PROFILE": "default"},
 		},
 		{
 			name:     "preserves top-level keys",
@@ -64,7 +64,7 @@ func TestService_Install(t *testing.T) {
 			wantKey:  ServerKey,
 			wantCmd:  binaryPath,
 			wantArgs: []interface{}{"connect", "--url", "http://localhost:8080/sse"},
-			wantEnv:  map[string]interface{}{"AWS_PROFILE": "default"},
+			wantEnv:  map[string]interface{}{"MCP_BRIDGE_PROFILE": "default"},
 			preserve: "theme",
 		},
 	}
@@ -159,26 +159,336 @@ func TestService_Install_invalidJSON(t *testing.T) {
 	}
 }
 
-func TestConfigPathByOS(t *testing.T) {
-	path, err := ConfigPathByOS()
-	if err != nil {
-		t.Fatalf("ConfigPathByOS() error = %v", err)
-	}
-	if path == "" {
-		t.Fatal("ConfigPathByOS() returned empty path")
-	}
-	switch runtime.GOOS {
-	case "windows":
-		if filepath.Base(path) != "claude_desktop_config.json" {
-			t.Errorf("path = %q, want filename claude_desktop_config.json", path)
-		}
-	default:
-		if filepath.Base(path) != "claude_desktop_config.json" {
-			t.Errorf("path = %q, want filename claude_desktop_config.json", path)
-		}
-		// darwin: .../Library/Application Support/Claude/...
-		if !strings.Contains(path, "Claude") {
-			t.Errorf("path should contain Claude: %q", path)
-		}
+func TestService_PlanInstall(t *testing.T) {
+	const binaryPath = "/usr/local/bin/mcp-bridge"
+	installed := `{
+  "mcpServers": {
+    "vertex-ai-rag": {
+      "args": [
+        "connect",
+        "--url",
+        "http://localhost:8080/sse"
+      ],
+      "command": "/usr/local/bin/mcp-bridge",
+      "env": {
+        "MCP_BRIDGE_PROFILE": "default"
+      }
+    }
+  }
+}`
+	tests := []struct {
+		name       string
+		initial    string // 空の場合は設定ファイルを作らない
+		url        string
+		wantAction PlanAction
+		wantPrev   bool
+		// wantDiff は Diff に含まれるべき行
+		wantDiff []string
+	}{
+		{
+			name:       "create",
+			url:        "http://localhost:8080/sse",
+			wantAction: ActionCreate,
+			wantDiff:   []string{"--- /dev/null", "+      \"command\": \"/usr/local/bin/mcp-bridge\","},
+		},
+		{
+			name:       "update keeps other servers",
+			initial:    `{"mcpServers":{"other":{"command":"other"}}}`,
+			url:        "http://localhost:8080/sse",
+			wantAction: ActionUpdate,
+			wantDiff: []string{
+				`-{"mcpServers":{"other":{"command":"other"}}}`,
+				`+{"mcpServers":{"other":{"command":"other"},"vertex-ai-rag":{"args":["connect","--url","http://localhost:8080/sse"],"command":"/usr/local/bin/mcp-bridge","env":{"MCP_BRIDGE_PROFILE":"default"}}}}`,
+			},
+		},
+		{
+			name:       "overwrite previous entry",
+			initial:    installed,
+			url:        "http://new:9090/sse",
+			wantAction: ActionUpdate,
+			wantPrev:   true,
+			wantDiff:   []string{`-        "http://localhost:8080/sse"`, `+        "http://new:9090/sse"`},
+		},
+		{
+			name:       "unchanged",
+			initial:    installed,
+			url:        "http://localhost:8080/sse",
+			wantAction: ActionUnchanged,
+			wantPrev:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "sub", "claude_desktop_config.json")
+			if tt.initial != "" {
+				if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(configPath, []byte(tt.initial), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			svc := &Service{ConfigPath: configPath}
+			plan, err := svc.PlanInstall(tt.url, "default", binaryPath)
+			if err != nil {
+				t.Fatalf("PlanInstall() error = %v", err)
+			}
+			if plan.Action != tt.wantAction {
+				t.Errorf("Action = %q, want %q", plan.Action, tt.wantAction)
+			}
+			if (plan.PreviousEntry != nil) != tt.wantPrev {
+				t.Errorf("PreviousEntry = %v, want present %v", plan.PreviousEntry, tt.wantPrev)
+			}
+			if plan.Target != "claude-desktop" || plan.ServersKey != "mcpServers" || plan.ConfigPath != configPath {
+				t.Errorf("plan = %+v", plan)
+			}
+			for _, line := range tt.wantDiff {
+				if !strings.Contains("\n"+plan.Diff, "\n"+line+"\n") {
+					t.Errorf("Diff does not contain %q:\n%s", line, plan.Diff)
+				}
+			}
+			if tt.wantAction == ActionUnchanged && plan.Diff != "" {
+				t.Errorf("Diff = %q, want empty", plan.Diff)
+			}
+
+			// PlanInstall はファイルもディレクトリも変更しない
+			data, err := os.ReadFile(configPath)
+			if tt.initial == "" {
+				if _, err := os.Stat(filepath.Dir(configPath)); !os.IsNotExist(err) {
+					t.Errorf("config directory created by PlanInstall: %v", err)
+				}
+			} else if err != nil || string(data) != tt.initial {
+				t.Errorf("config changed by PlanInstall: %s, %v", data, err)
+			}
+
+			if err := svc.Apply(plan); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if tt.wantAction != ActionUnchanged {
+				if _, err := os.Stat(configPath); err != nil {
+					t.Errorf("config not written by Apply: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestService_Uninstall(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial string // 空の場合は設定ファイルを作らない
+		// want は Uninstall 後の設定ファイルの内容（空白を詰めたもの）。空の場合は initial から変わらないことを確認する
+		want        string
+		wantRemoved bool
+	}{
+		{
+			name:        "removes only our entry",
+			initial:     `{"theme":"dark","mcpServers":{"other":{"command":"other","args":["--port",8080]},"vertex-ai-rag":{"command":"old"}}}`,
+			want:        `{"theme":"dark","mcpServers":{"other":{"command":"other","args":["--port",8080]}}}`,
+			wantRemoved: true,
+		},
+		{
+			name:        "keeps empty mcpServers",
+			initial:     `{"mcpServers":{"vertex-ai-rag":{"command":"old"}}}`,
+			want:        `{"mcpServers":{}}`,
+			wantRemoved: true,
+		},
+		{
+			name:        "keeps large numbers as written",
+			initial:     `{"mcpServers":{"vertex-ai-rag":{}},"launchCount":12345678901234567890}`,
+			want:        `{"mcpServers":{},"launchCount":12345678901234567890}`,
+			wantRemoved: true,
+		},
+		{
+			name:    "not registered",
+			initial: `{"theme": "dark", "mcpServers": {"other": {"command": "other"}}}`,
+		},
+		{
+			name:    "no mcpServers",
+			initial: `{"theme": "dark"}`,
+		},
+		{
+			name: "no config file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "claude_desktop_config.json")
+			if tt.initial != "" {
+				if err := os.WriteFile(configPath, []byte(tt.initial), 0600); err != nil {
+					t.Fatalf("write initial: %v", err)
+				}
+			}
+
+			svc := &Service{ConfigPath: configPath}
+			res, err := svc.Uninstall()
+			if err != nil {
+				t.Fatalf("Uninstall() error = %v", err)
+			}
+			if res.Removed != tt.wantRemoved {
+				t.Errorf("Removed = %v, want %v", res.Removed, tt.wantRemoved)
+			}
+			if res.ConfigPath != configPath {
+				t.Errorf("ConfigPath = %q, want %q", res.ConfigPath, configPath)
+			}
+
+			data, err := os.ReadFile(configPath)
+			if tt.initial == "" {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("config file created: err = %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read config: %v", err)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.initial
+			}
+			if got := strings.Join(strings.Fields(string(data)), ""); got != strings.Join(strings.Fields(want), "") {
+				t.Errorf("config = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestService_Uninstall_invalidJSON(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "claude_desktop_config.json")
+	if err := os.WriteFile(configPath, []byte(`{invalid`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{ConfigPath: configPath}
+	if _, err := svc.Uninstall(); err == nil {
+		t.Fatal("Uninstall() expected error for invalid JSON")
+	}
+	data, _ := os.ReadFile(configPath)
+	if string(data) != `{invalid` {
+		t.Errorf("config rewritten: %s", data)
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	tests := []struct {
+		name string
+		goos string
+		// env の値の $HOME はテスト用のホームディレクトリに置き換える
+		env map[string]string
+		// dirs は home からの相対パスで作成しておくディレクトリ
+		dirs []string
+		// want は home からの相対パス
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "darwin",
+			goos: "darwin",
+			want: "Library/Application Support/Claude/claude_desktop_config.json",
+		},
+		{
+			name: "windows",
+			goos: "windows",
+			env:  map[string]string{"APPDATA": "$HOME/AppData/Roaming"},
+			want: "AppData/Roaming/Claude/claude_desktop_config.json",
+		},
+		{
+			name:    "windows without APPDATA",
+			goos:    "windows",
+			wantErr: true,
+		},
+		{
+			name: "linux default XDG",
+			goos: "linux",
+			dirs: []string{".config/Claude"},
+			want: ".config/Claude/claude_desktop_config.json",
+		},
+		{
+			name: "linux XDG_CONFIG_HOME",
+			goos: "linux",
+			env:  map[string]string{"XDG_CONFIG_HOME": "$HOME/xdg"},
+			dirs: []string{"xdg/Claude", ".config/Claude"},
+			want: "xdg/Claude/claude_desktop_config.json",
+		},
+		{
+			name: "linux relative XDG_CONFIG_HOME is ignored",
+			goos: "linux",
+			env:  map[string]string{"XDG_CONFIG_HOME": "relative"},
+			dirs: []string{".config/Claude"},
+			want: ".config/Claude/claude_desktop_config.json",
+		},
+		{
+			name: "linux flatpak",
+			goos: "linux",
+			dirs: []string{".var/app/com.example.Claude/config/Claude"},
+			want: ".var/app/com.example.Claude/config/Claude/claude_desktop_config.json",
+		},
+		{
+			name: "linux snap",
+			goos: "linux",
+			dirs: []string{"snap/claude-desktop/current/.config/Claude"},
+			want: "snap/claude-desktop/current/.config/Claude/claude_desktop_config.json",
+		},
+		{
+			name: "linux native install wins over sandboxed",
+			goos: "linux",
+			dirs: []string{".config/Claude", ".var/app/com.example.Claude/config/Claude"},
+			want: ".config/Claude/claude_desktop_config.json",
+		},
+		{
+			name:    "linux without Claude",
+			goos:    "linux",
+			dirs:    []string{".config/other", ".var/app/org.example.Other/config"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported OS",
+			goos:    "plan9",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			for _, d := range tt.dirs {
+				if err := os.MkdirAll(filepath.Join(home, d), 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p := hostPlatform()
+			p.goos = tt.goos
+			p.homeDir = func() (string, error) { return home, nil }
+			p.getenv = func(key string) string {
+				return strings.ReplaceAll(tt.env[key], "$HOME", home)
+			}
+
+			got, err := configPath(p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("configPath() = %q, %v, wantErr %v", got, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if want := filepath.Join(home, tt.want); got != want {
+				t.Errorf("configPath() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigPath_notFoundError(t *testing.T) {
+	home := t.TempDir()
+	p := hostPlatform()
+	p.goos = "linux"
+	p.homeDir = func() (string, error) { return home, nil }
+	p.getenv = func(string) string { return "" }
+
+	_, err := configPath(p)
+	var nf *NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("configPath() error = %v, want *NotFoundError", err)
+	}
+	if len(nf.Searched) != 3 || !strings.Contains(err.Error(), filepath.Join(home, ".config", "Claude")) {
+		t.Errorf("error = %q, want the searched locations", err)
 	}
 }
@@ -0,0 +1,201 @@
+package installer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// TargetAll は --target で検出された全ての MCP クライアントを指定する名前です。
+const TargetAll = "all"
+
+// Target はブリッジを登録する MCP クライアント（Claude Desktop、Cursor など）です。
+// クライアントごとに設定ファイルの場所と、サーバーを並べるキーやエントリの形式が異なります。
+type Target interface {
+	// Name は --target で指定する名前（例: cursor）
+	Name() string
+	// DisplayName は表示用の名前（例: Cursor）
+	DisplayName() string
+	// ConfigPath は設定ファイルのパスを返します。ファイルが存在しない場合でもパスを返します。
+	// パスを特定できない場合はエラー（インストールが見つからない場合は *NotFoundError）を返します。
+	ConfigPath() (string, error)
+	// Detected はクライアントがインストールされているように見えるかどうかを返します（--target all で使用）。
+	Detected() bool
+	// ServersKey は設定ファイルのトップレベルで MCP サーバーを並べるオブジェクトのキーです。
+	ServersKey() string
+	// Entry は ServersKey の下に ServerKey で追加するエントリを返します。
+	Entry(command string, args []string, env map[string]string) map[string]interface{}
+}
+
+// client は組み込みの Target の実装です。
+type client struct {
+	name        string
+	displayName string
+	serversKey  string
+	p           platform
+	// path は設定ファイルのパスを返す
+	path func(p platform) (string, error)
+	// detect はインストールされているかを返す。nil の場合は設定ファイルのディレクトリがあるかで判定する
+	detect func(p platform) bool
+	// extra はエントリに command / args / env と並べて追加するメンバー（例: VS Code の "type": "stdio"）
+	extra map[string]interface{}
+}
+
+func (c *client) Name() string        { return c.name }
+func (c *client) DisplayName() string { return c.displayName }
+func (c *client) ServersKey() string  { return c.serversKey }
+
+func (c *client) ConfigPath() (string, error) {
+	return c.path(c.p)
+}
+
+func (c *client) Detected() bool {
+	if c.detect != nil {
+		return c.detect(c.p)
+	}
+	path, err := c.path(c.p)
+	return err == nil && c.p.isDir(filepath.Dir(path))
+}
+
+func (c *client) Entry(command string, args []string, env map[string]string) map[string]interface{} {
+	a := make([]interface{}, len(args))
+	for i, v := range args {
+		a[i] = v
+	}
+	e := make(map[string]interface{}, len(env))
+	for k, v := range env {
+		e[k] = v
+	}
+	entry := map[string]interface{}{
+		"command": command,
+		"args":    a,
+		"env":     e,
+	}
+	for k, v := range c.extra {
+		entry[k] = v
+	}
+	return entry
+}
+
+// Targets は組み込みの全ての Target を返します。
+func Targets() []Target {
+	return targets(hostPlatform())
+}
+
+// TargetByName は name の Target を返します。
+func TargetByName(name string) (Target, error) {
+	all := Targets()
+	names := make([]string, 0, len(all))
+	for _, t := range all {
+		if t.Name() == name {
+			return t, nil
+		}
+		names = append(names, t.Name())
+	}
+	return nil, fmt.Errorf("不明な --target です: %s（%s, %s のいずれかを指定してください）", name, strings.Join(names, ", "), TargetAll)
+}
+
+// DetectedTargets はインストールされているように見える Target を返します。
+func DetectedTargets() []Target {
+	var found []Target
+	for _, t := range Targets() {
+		if t.Detected() {
+			found = append(found, t)
+		}
+	}
+	return found
+}
+
+func targets(p platform) []Target {
+	return []Target{
+		&client{
+			name:        "claude-desktop",
+			displayName: "Claude Desktop",
+			serversKey:  "mcpServers",
+			p:           p,
+			path:        configPath,
+		},
+		&client{
+			name:        "cursor",
+			displayName: "Cursor",
+			serversKey:  "mcpServers",
+			p:           p,
+			path:        inHome(".cursor", "mcp.json"),
+		},
+		&client{
+			name:        "vscode",
+			displayName: "VS Code",
+			serversKey:  "servers",
+			p:           p,
+			path:        inUserConfig("Code", "User", "mcp.json"),
+			extra:       map[string]interface{}{"type": "stdio"},
+		},
+		&client{
+			name:        "claude-code",
+			displayName: "Claude Code",
+			serversKey:  "mcpServers",
+			p:           p,
+			path:        inHome(".claude.json"),
+			// ~/.claude.json はホーム直下にあるので、Claude Code が作る ~/.claude ディレクトリで判定する
+			detect: func(p platform) bool {
+				home, err := p.homeDir()
+				return err == nil && p.isDir(filepath.Join(home, ".claude"))
+			},
+			extra: map[string]interface{}{"type": "stdio"},
+		},
+		&client{
+			name:        "windsurf",
+			displayName: "Windsurf",
+			serversKey:  "mcpServers",
+			p:           p,
+			path:        inHome(".codeium", "windsurf", "mcp_config.json"),
+		},
+		&client{
+			name:        "zed",
+			displayName: "Zed",
+			serversKey:  "context_servers",
+			p:           p,
+			path:        zedSettingsPath,
+			extra:       map[string]interface{}{"source": "custom"},
+		},
+	}
+}
+
+// inHome は OS によらずホームディレクトリ直下の elem にある設定ファイルのパスを返す関数を返します。
+func inHome(elem ...string) func(p platform) (string, error) {
+	return func(p platform) (string, error) {
+		home, err := p.homeDir()
+		if err != nil {
+			return "", fmt.Errorf("ホームディレクトリの取得に失敗しました: %w", err)
+		}
+		return filepath.Join(append([]string{home}, elem...)...), nil
+	}
+}
+
+// inUserConfig は OS のユーザー設定ディレクトリの下の elem にある設定ファイルのパスを返す関数を返します。
+func inUserConfig(elem ...string) func(p platform) (string, error) {
+	return func(p platform) (string, error) {
+		dir, err := userConfigDir(p)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(append([]string{dir}, elem...)...), nil
+	}
+}
+
+// zedSettingsPath は Zed の設定ファイルのパスを返します。
+// Zed は macOS でも ~/Library/Application Support ではなく ~/.config/zed を使います。
+func zedSettingsPath(p platform) (string, error) {
+	switch p.goos {
+	case "windows":
+		return inUserConfig("Zed", "settings.json")(p)
+	case "darwin":
+		return inHome(".config", "zed", "settings.json")(p)
+	default:
+		dir, err := xdgConfigHome(p)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "zed", "settings.json"), nil
+	}
+}
@@ -0,0 +1,180 @@
+package installer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTargets_ConfigPath(t *testing.T) {
+	tests := []struct {
+		target string
+		goos   string
+		// want は home からの相対パス（APPDATA は $HOME/AppData/Roaming、XDG_CONFIG_HOME は未設定）
+		want string
+	}{
+		{target: "cursor", goos: "darwin", want: ".cursor/mcp.json"},
+		{target: "cursor", goos: "windows", want: ".cursor/mcp.json"},
+		{target: "vscode", goos: "darwin", want: "Library/Application Support/Code/User/mcp.json"},
+		{target: "vscode", goos: "windows", want: "AppData/Roaming/Code/User/mcp.json"},
+		{target: "vscode", goos: "linux", want: ".config/Code/User/mcp.json"},
+		{target: "claude-code", goos: "linux", want: ".claude.json"},
+		{target: "windsurf", goos: "darwin", want: ".codeium/windsurf/mcp_config.json"},
+		{target: "zed", goos: "darwin", want: ".config/zed/settings.json"},
+		{target: "zed", goos: "linux", want: ".config/zed/settings.json"},
+		{target: "zed", goos: "windows", want: "AppData/Roaming/Zed/settings.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target+"/"+tt.goos, func(t *testing.T) {
+			home := t.TempDir()
+			got, err := findTarget(t, fakePlatform(tt.goos, home), tt.target).ConfigPath()
+			if err != nil {
+				t.Fatalf("ConfigPath() error = %v", err)
+			}
+			if want := filepath.Join(home, tt.want); got != want {
+				t.Errorf("ConfigPath() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestTargets_Detected(t *testing.T) {
+	home := t.TempDir()
+	for _, d := range []string{".cursor", ".claude", ".config/zed"} {
+		if err := os.MkdirAll(filepath.Join(home, d), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var detected []string
+	for _, target := range targets(fakePlatform("linux", home)) {
+		if target.Detected() {
+			detected = append(detected, target.Name())
+		}
+	}
+	if got, want := strings.Join(detected, ","), "cursor,claude-code,zed"; got != want {
+		t.Errorf("detected = %s, want %s", got, want)
+	}
+}
+
+func TestService_Install_target(t *testing.T) {
+	tests := []struct {
+		target  string
+		initial string
+		// wantKey は vertex-ai-rag を並べるキー、wantExtra はエントリに追加されるメンバー
+		wantKey   string
+		wantExtra map[string]string
+	}{
+		{target: "cursor", wantKey: "mcpServers"},
+		{target: "vscode", wantKey: "servers", wantExtra: map[string]string{"type": "stdio"}},
+		{target: "claude-code", initial: `{"numStartups": 3, "projects": {}}`, wantKey: "mcpServers", wantExtra: map[string]string{"type": "stdio"}},
+		{target: "windsurf", wantKey: "mcpServers"},
+		{
+			target:    "zed",
+			initial:   "// Zed settings\n{\n  \"theme\": \"One Dark\", // comment\n  \"context_servers\": {\"other\": {\"command\": \"x\"},},\n}\n",
+			wantKey:   "context_servers",
+			wantExtra: map[string]string{"source": "custom"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			home := t.TempDir()
+			target := findTarget(t, fakePlatform("linux", home), tt.target)
+			configPath, err := target.ConfigPath()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.initial != "" {
+				if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(configPath, []byte(tt.initial), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			svc := &Service{Target: target}
+			if err := svc.Install("http://localhost:8080/sse", "default", "/usr/local/bin/mcp-bridge"); err != nil {
+				t.Fatalf("Install() error = %v", err)
+			}
+			root := readJSON(t, configPath)
+			servers, _ := root[tt.wantKey].(map[string]interface{})
+			ent, ok := servers[ServerKey].(map[string]interface{})
+			if !ok {
+				t.Fatalf("%s[%q] missing: %v", tt.wantKey, ServerKey, root)
+			}
+			if ent["command"] != "/usr/local/bin/mcp-bridge" {
+				t.Errorf("command = %v", ent["command"])
+			}
+			for k, v := range tt.wantExtra {
+				if ent[k] != v {
+					t.Errorf("%s = %v, want %q", k, ent[k], v)
+				}
+			}
+			if tt.target == "zed" {
+				if _, ok := servers["other"]; !ok || root["theme"] != "One Dark" {
+					t.Errorf("existing settings lost: %v", root)
+				}
+				data, _ := os.ReadFile(configPath)
+				if !strings.Contains(string(data), "// Zed settings\n") || !strings.Contains(string(data), `"One Dark", // comment`) {
+					t.Errorf("comments lost:\n%s", data)
+				}
+			}
+
+			res, err := svc.Uninstall()
+			if err != nil || !res.Removed {
+				t.Fatalf("Uninstall() = %+v, %v", res, err)
+			}
+			servers, _ = readJSON(t, configPath)[tt.wantKey].(map[string]interface{})
+			if _, ok := servers[ServerKey]; ok {
+				t.Errorf("%s[%q] still present after Uninstall", tt.wantKey, ServerKey)
+			}
+			// 既存の設定ファイルは Install と Uninstall で元の内容に戻る
+			if tt.target == "zed" {
+				if data, _ := os.ReadFile(configPath); string(data) != tt.initial {
+					t.Errorf("config after Uninstall =\n%s\nwant\n%s", data, tt.initial)
+				}
+			}
+		})
+	}
+}
+
+// fakePlatform はホームディレクトリを home に、APPDATA を home/AppData/Roaming にした goos のプラットフォームを返します。
+func fakePlatform(goos, home string) platform {
+	p := hostPlatform()
+	p.goos = goos
+	p.homeDir = func() (string, error) { return home, nil }
+	p.getenv = func(key string) string {
+		if key == "APPDATA" {
+			return filepath.Join(home, "AppData", "Roaming")
+		}
+		return ""
+	}
+	return p
+}
+
+func findTarget(t *testing.T, p platform, name string) Target {
+	t.Helper()
+	for _, target := range targets(p) {
+		if target.Name() == name {
+			return target
+		}
+	}
+	t.Fatalf("target %q not found", name)
+	return nil
+}
+
+func readJSON(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	var root map[string]interface{}
+	if err := json.Unmarshal(stripJSONC(data), &root); err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	return root
+}
@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// callTable は実行中のリクエストを管理します。
+// サーバーへはクライアントの id の代わりにブリッジ内で一意な id を付けて送り、レスポンスの id から元のリクエストを引いて
+// クライアントの id に戻します。クライアントの id が重複していたり null や数値・文字列以外であっても、
+// 別々のリクエストのレスポンスが取り違えられることはありません。
+type callTable struct {
+	mu   sync.Mutex
+	next int64
+	// byBridge はブリッジが割り当てた id（idKey）から実行中のリクエストを引く表
+	byBridge map[string]*call
+	// byClient はクライアントの id（idKey）から実行中のリクエストを引く表（notifications/cancelled 用）
+	byClient map[string]*call
+}
+
+// call は実行中の 1 件のリクエストです。
+type call struct {
+	// clientID はクライアントが付けた元の id（生の JSON）
+	clientID json.RawMessage
+	// bridgeID はサーバーへ送る際に付けた id（生の JSON）
+	bridgeID  json.RawMessage
+	clientKey string
+	cancel    context.CancelFunc
+	// method はリクエストの method（ログとエラーメッセージ用）
+	method string
+	// timeout はレスポンスを待つ時間。0 の場合は待ち続ける。
+	timeout time.Duration
+
+	once sync.Once
+	// done はサーバーのレスポンスを受け取るか、クライアントがキャンセルすると閉じられる
+	done chan struct{}
+	resp []byte
+	// cancelled はクライアントがキャンセルしたかどうか。true の場合 resp は nil で、以降のレスポンスは受け付けない
+	cancelled bool
+}
+
+func newCallTable() *callTable {
+	return &callTable{
+		byBridge: make(map[string]*call),
+		byClient: make(map[string]*call),
+	}
+}
+
+// start はリクエスト msg を登録してブリッジの id を割り当てます。
+// キャンセル可能な（timeout が正なら timeout で期限の切れる）ctx と、id を置き換えたサーバーへ送るメッセージを返します。
+// 完了したら finish を呼んでください。
+func (t *callTable) start(ctx context.Context, msg []byte, timeout time.Duration) (*call, context.Context, []byte, error) {
+	clientID, ok, err := member(msg, "id")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !ok {
+		return nil, nil, nil, errors.New("request has no id")
+	}
+
+	t.mu.Lock()
+	t.next++
+	bridgeID := json.RawMessage(strconv.FormatInt(t.next, 10))
+	t.mu.Unlock()
+
+	outbound, err := setMember(msg, "id", bridgeID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	c := &call{
+		clientID:  append(json.RawMessage(nil), clientID...),
+		bridgeID:  bridgeID,
+		clientKey: idKey(clientID),
+		cancel:    cancel,
+		method:    requestMethod(msg),
+		timeout:   timeout,
+		done:      make(chan struct{}),
+	}
+
+	t.mu.Lock()
+	t.byBridge[idKey(bridgeID)] = c
+	// クライアントが同じ id を重複して使った場合、キャンセル通知は後から来たリクエストに適用する
+	t.byClient[c.clientKey] = c
+	t.mu.Unlock()
+	return c, ctx, outbound, nil
+}
+
+// finish は c を表から取り除きます。以降に届いた c へのレスポンスは捨てられます。
+func (t *callTable) finish(c *call) {
+	c.cancel()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.byBridge, idKey(c.bridgeID))
+	if t.byClient[c.clientKey] == c {
+		delete(t.byClient, c.clientKey)
+	}
+}
+
+// inflight は実行中のリクエストの数を返します。
+func (t *callTable) inflight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.byBridge)
+}
+
+// cancel はクライアントの id が clientKey のリクエストをキャンセルし、サーバー側でそのリクエストを指す id を返します。
+// キャンセルしたリクエストは以降のレスポンスを受け付けないので、POST の応答として遅れて届いたレスポンスもクライアントには返りません。
+// 実行中のリクエストが見つからない場合は ok に false を返します。
+func (t *callTable) cancel(clientKey string) (bridgeID json.RawMessage, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.byClient[clientKey]
+	if !ok {
+		return nil, false
+	}
+	c.cancel()
+	c.once.Do(func() {
+		c.cancelled = true
+		close(c.done)
+	})
+	delete(t.byClient, clientKey)
+	delete(t.byBridge, idKey(c.bridgeID))
+	return c.bridgeID, true
+}
+
+// resolve はサーバーのレスポンス resp を id の一致するリクエストに渡します。
+// id が null（または欠損）のエラーレスポンスは、実行中のリクエストが 1 件だけで取り違えようがない場合に限りそのリクエストに渡します。
+// 渡し先が見つかった場合は true を返します。
+func (t *callTable) resolve(resp []byte) bool {
+	id, _, err := member(resp, "id")
+	if err != nil {
+		return false
+	}
+
+	t.mu.Lock()
+	var c *call
+	if isNullID(id) {
+		if len(t.byBridge) == 1 {
+			for _, only := range t.byBridge {
+				c = only
+			}
+		}
+	} else {
+		c = t.byBridge[idKey(id)]
+	}
+	t.mu.Unlock()
+
+	if c == nil {
+		return false
+	}
+	return c.resolve(resp)
+}
+
+// claimResponse は POST の応答として返ったレスポンス resp を、その POST で送った calls のうち id の一致するものに渡します。
+// id が null のエラーは、その POST で送ったリクエストによるものとみなし、calls のうち未完了のものが 1 件だけならそれに渡します。
+// それ以外の null のエラー（どのリクエストによるものか分からない、または通知に対するもの）は捨てます。
+// resp を処理した場合は true を、calls 宛てでない場合は false を返します。
+func claimResponse(calls []*call, resp []byte) bool {
+	id, _, err := member(resp, "id")
+	if err != nil {
+		return false
+	}
+	if isNullID(id) {
+		var pending []*call
+		for _, c := range calls {
+			if !c.resolved() {
+				pending = append(pending, c)
+			}
+		}
+		if len(pending) == 1 {
+			pending[0].resolve(resp)
+		}
+		return true
+	}
+	for _, c := range calls {
+		if idKey(id) == idKey(c.bridgeID) {
+			c.resolve(resp)
+			return true
+		}
+	}
+	return false
+}
+
+// pending は calls のうちレスポンスを受け取っていないものがあるかどうかを返します。
+func pending(calls []*call) bool {
+	for _, c := range calls {
+		if !c.resolved() {
+			return true
+		}
+	}
+	return false
+}
+
+// resolved は c がレスポンスを受け取り済みかどうかを返します。
+func (c *call) resolved() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolve は c のレスポンスを記録します。最初の 1 件だけを受け付け、受け付けた場合は true を返します。
+// キャンセル済みの c のレスポンスは受け付けません。
+func (c *call) resolve(resp []byte) bool {
+	accepted := false
+	c.once.Do(func() {
+		c.resp = resp
+		accepted = true
+		close(c.done)
+	})
+	return accepted
+}
+
+// response は受け取ったレスポンスの id をクライアントの元の id に戻して返します。done が閉じられた後に呼んでください。
+func (c *call) response() ([]byte, error) {
+	return setMember(c.resp, "id", c.clientID)
+}
+
+// isNullID は id が null または欠損（nil）かどうかを判定します。
+func isNullID(id json.RawMessage) bool {
+	return id == nil || idKey(id) == "null"
+}
+
+// idKey は JSON-RPC の id（生の JSON）を表の検索キーに変換します。空白の違いは無視します。
+func idKey(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// cancelledRequestID は notifications/cancelled の params.requestId を返します。
+// msg がキャンセル通知でない場合は ok に false を返します。
+func cancelledRequestID(msg []byte) (id string, ok bool) {
+	var m struct {
+		Method string `json:"method"`
+		Params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil || m.Method != "notifications/cancelled" || m.Params.RequestID == nil {
+		return "", false
+	}
+	return idKey(m.Params.RequestID), true
+}
+
+// withCancelledRequestID は notifications/cancelled の params.requestId を id に置き換えたメッセージを返します。
+func withCancelledRequestID(msg []byte, id json.RawMessage) ([]byte, error) {
+	params, _, err := member(msg, "params")
+	if err != nil {
+		return nil, err
+	}
+	params, err = setMember(params, "requestId", id)
+	if err != nil {
+		return nil, err
+	}
+	return setMember(msg, "params", params)
+}
+
+// member は JSON オブジェクト obj のトップレベルのメンバー key の値を生の JSON のまま返します。
+func member(obj []byte, key string) (json.RawMessage, bool, error) {
+	start, end, err := memberSpan(obj, key)
+	if err != nil || start < 0 {
+		return nil, false, err
+	}
+	return json.RawMessage(obj[start:end]), true, nil
+}
+
+// setMember は JSON オブジェクト obj のトップレベルのメンバー key の値を val に置き換えたコピーを返します。
+// 他のメンバーの並びや書式はそのまま残します。key が無い場合は先頭に追加します。
+func setMember(obj []byte, key string, val json.RawMessage) ([]byte, error) {
+	start, end, err := memberSpan(obj, key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(obj)+len(val)+len(key)+4)
+	if start >= 0 {
+		out = append(out, obj[:start]...)
+		out = append(out, val...)
+		return append(out, obj[end:]...), nil
+	}
+
+	open := bytes.IndexByte(obj, '{')
+	name, _ := json.Marshal(key)
+	out = append(out, obj[:open+1]...)
+	out = append(out, name...)
+	out = append(out, ':')
+	out = append(out, val...)
+	rest := obj[open+1:]
+	if len(bytes.TrimSpace(rest)) > 0 && bytes.TrimSpace(rest)[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, rest...), nil
+}
+
+// memberSpan は JSON オブジェクト obj のトップレベルのメンバー key の値の位置 [start, end) を返します。
+// key が無い場合は start に -1 を返します。obj がオブジェクトでない場合はエラーを返します。
+func memberSpan(obj []byte, key string) (start, end int, err error) {
+	dec := json.NewDecoder(bytes.NewReader(obj))
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, 0, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return 0, 0, errors.New("not a JSON object")
+	}
+	start = -1
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, 0, err
+		}
+		name, _ := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return 0, 0, err
+		}
+		// 重複したキーは encoding/json と同じく最後のものを採用する
+		if name == key {
+			end = int(dec.InputOffset())
+			start = end - len(raw)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
@@ -0,0 +1,387 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+)
+
+func TestCallTable_resolve(t *testing.T) {
+	tests := []struct {
+		name string
+		// clients は登録するリクエストのクライアント側の id。ブリッジの id は 1 から順に割り当てられる
+		clients  []string
+		finished []int
+		response string
+		// want は resolve されるべきリクエストの添字。-1 はどれにも渡されないこと
+		want int
+	}{
+		{"bridge id", []string{"1", "1"}, nil, `{"jsonrpc":"2.0","result":{},"id":2}`, 1},
+		{"client id is not looked up", []string{`"a"`, "7"}, nil, `{"jsonrpc":"2.0","result":{},"id":"a"}`, -1},
+		{"number vs string", []string{"1"}, nil, `{"jsonrpc":"2.0","result":{},"id":"1"}`, -1},
+		{"finished request", []string{"1", "2"}, []int{0}, `{"jsonrpc":"2.0","result":{},"id":1}`, -1},
+		{"null id with a single request", []string{"null"}, nil, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"x"},"id":null}`, 0},
+		{"missing id with a single request", []string{`{"k":1}`}, nil, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"x"}}`, 0},
+		{"null id is ambiguous", []string{"1", "2"}, nil, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"x"},"id":null}`, -1},
+		{"null id after the other finished", []string{"1", "2"}, []int{1}, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"x"},"id":null}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newCallTable()
+			var calls []*call
+			for _, id := range tt.clients {
+				c, _, _, err := table.start(context.Background(), []byte(`{"jsonrpc":"2.0","method":"m","id":`+id+`}`), 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer table.finish(c)
+				calls = append(calls, c)
+			}
+			for _, i := range tt.finished {
+				table.finish(calls[i])
+			}
+
+			got := table.resolve([]byte(tt.response))
+			if got != (tt.want >= 0) {
+				t.Fatalf("resolve() = %v, want %v", got, tt.want >= 0)
+			}
+			for i, c := range calls {
+				select {
+				case <-c.done:
+					if i != tt.want {
+						t.Errorf("request %d resolved, want %d", i, tt.want)
+					}
+				default:
+					if i == tt.want {
+						t.Errorf("request %d not resolved", i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCallTable_cancel(t *testing.T) {
+	table := newCallTable()
+	first, _, _, _ := table.start(context.Background(), []byte(`{"jsonrpc":"2.0","method":"m","id":"x"}`), 0)
+	defer table.finish(first)
+	second, ctx, _, _ := table.start(context.Background(), []byte(`{"jsonrpc":"2.0","method":"m","id":"x"}`), 0)
+	defer table.finish(second)
+
+	// 重複した id のキャンセルは後から来たリクエストに適用する
+	bridgeID, ok := table.cancel(`"x"`)
+	if !ok || string(bridgeID) != "2" {
+		t.Fatalf("cancel() = %s, %v, want 2, true", bridgeID, ok)
+	}
+	if ctx.Err() == nil {
+		t.Error("cancelled request context is still alive")
+	}
+	if table.resolve([]byte(`{"jsonrpc":"2.0","result":{},"id":2}`)) {
+		t.Error("late response to a cancelled request was resolved")
+	}
+	if _, ok := table.cancel(`"x"`); ok {
+		t.Error("second cancel() found a request")
+	}
+}
+
+func TestSetMember(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  string
+		key  string
+		val  string
+		want string
+	}{
+		{"replace number", `{"jsonrpc":"2.0","result":{},"id":1}`, "id", `"a"`, `{"jsonrpc":"2.0","result":{},"id":"a"}`},
+		{"keep formatting", `{ "id" : 1 , "result" : { "id" : 2 } }`, "id", `null`, `{ "id" : null , "result" : { "id" : 2 } }`},
+		{"nested key untouched", `{"result":{"id":2}}`, "id", `3`, `{"id":3,"result":{"id":2}}`},
+		{"empty object", `{}`, "id", `3`, `{"id":3}`},
+		{"duplicate keys use the last", `{"id":1,"id":2}`, "id", `3`, `{"id":1,"id":3}`},
+		{"object id", `{"id":{"a":[1,2]},"x":1}`, "id", `5`, `{"id":5,"x":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := setMember([]byte(tt.obj), tt.key, json.RawMessage(tt.val))
+			if err != nil {
+				t.Fatalf("setMember() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("setMember() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// FuzzCallTable_roundTrip は、どのような id のリクエストでもサーバーへは一意な id で送られ、
+// レスポンスではクライアントの元の id がそのまま戻ることを確かめます。
+func FuzzCallTable_roundTrip(f *testing.F) {
+	for _, seed := range []string{`1`, `0`, `-1.5e3`, `"a"`, `""`, `null`, `{"x":[1]}`, `[1,"2"]`, `true`, `"\u00e9\n"`, ` 7 `} {
+		f.Add(seed, `{"ok":true}`)
+	}
+	f.Fuzz(func(t *testing.T, id, result string) {
+		if !json.Valid([]byte(id)) || !json.Valid([]byte(result)) {
+			t.Skip()
+		}
+		req := []byte(`{"jsonrpc":"2.0","method":"m","id":` + id + `}`)
+		table := newCallTable()
+		first, _, outFirst, err := table.start(context.Background(), req, 0)
+		if err != nil {
+			t.Fatalf("start(%s) error = %v", req, err)
+		}
+		defer table.finish(first)
+		second, _, outSecond, err := table.start(context.Background(), req, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer table.finish(second)
+
+		firstID, _, _ := member(outFirst, "id")
+		secondID, _, _ := member(outSecond, "id")
+		if idKey(firstID) == idKey(secondID) {
+			t.Fatalf("duplicate client ids were sent with the same upstream id %s", firstID)
+		}
+
+		// 応答順を入れ替えても、それぞれ自分のレスポンスを受け取る
+		for _, c := range []*call{second, first} {
+			resp := []byte(`{"jsonrpc":"2.0","result":` + result + `,"id":` + string(c.bridgeID) + `}`)
+			if !table.resolve(resp) {
+				t.Fatalf("resolve(%s) = false", resp)
+			}
+			got, err := c.response()
+			if err != nil {
+				t.Fatalf("response() error = %v", err)
+			}
+			want := `{"jsonrpc":"2.0","result":` + result + `,"id":` + strings.TrimSpace(id) + `}`
+			if !json.Valid(got) || idKey(got) != idKey(json.RawMessage(want)) {
+				t.Fatalf("response() = %s, want %s", got, want)
+			}
+		}
+	})
+}
+
+// cancelTransport は "slow" リクエストのキャンセルを記録し、キャンセル後に遅れてレスポンスを返します
+// （SSE で遅れて届くレスポンスの再現）。
+type cancelTransport struct {
+	started chan struct{}
+
+	mu            sync.Mutex
+	cancelled     bool
+	notifications []string
+}
+
+func (c *cancelTransport) send(ctx context.Context, msg []byte, ch chan<- []byte) error {
+	var m struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	_ = json.Unmarshal(msg, &m)
+	if m.ID == nil {
+		c.mu.Lock()
+		c.notifications = append(c.notifications, string(msg))
+		c.mu.Unlock()
+		return nil
+	}
+	close(c.started)
+	<-ctx.Done()
+	c.mu.Lock()
+	c.cancelled = true
+	c.mu.Unlock()
+	ch <- []byte(fmt.Sprintf(`{"jsonrpc":"2.0","result":{"late":true},"id":%s}`, m.ID))
+	return ctx.Err()
+}
+
+func (c *cancelTransport) listen(context.Context, chan<- []byte) {}
+
+func TestProxy_cancelledNotification(t *testing.T) {
+	stdinR, stdinW := io.Pipe()
+	var stdout bytes.Buffer
+	tr := &cancelTransport{started: make(chan struct{})}
+	p := &Proxy{
+		cfg:    &config.Config{},
+		stdin:  stdinR,
+		stdout: &stdout,
+		calls:  newCallTable(),
+	}
+
+	ch := make(chan []byte, 8)
+	writerDone := make(chan struct{})
+	go func() {
+		p.writeStdout(ch)
+		close(writerDone)
+	}()
+	readerDone := make(chan struct{})
+	go func() {
+		p.runStdinToPost(context.Background(), newShutdown(), tr, ch)
+		close(readerDone)
+	}()
+
+	fmt.Fprintln(stdinW, `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"search_documents"},"id":42}`)
+	select {
+	case <-tr.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not sent")
+	}
+	fmt.Fprintln(stdinW, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":42,"reason":"user"}}`)
+	stdinW.Close()
+	<-readerDone
+	close(ch)
+	<-writerDone
+
+	if !tr.cancelled {
+		t.Error("upstream request context was not cancelled")
+	}
+	// サーバーにはブリッジが割り当てた id でキャンセルを伝える
+	want := `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1,"reason":"user"}}`
+	if len(tr.notifications) != 1 || tr.notifications[0] != want {
+		t.Errorf("forwarded notifications = %v, want [%s]", tr.notifications, want)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("stdout = %q, want no late response or error for the cancelled request", stdout.String())
+	}
+}
+
+func TestProxy_cancelledNotification_queued(t *testing.T) {
+	// 同時実行数の上限で空きを待っているリクエストも、直後に届いたキャンセルで止まる
+	stdinR, stdinW := io.Pipe()
+	tr := &fakeTransport{release: make(chan struct{})}
+	p := &Proxy{
+		cfg:   &config.Config{MaxConcurrency: 1},
+		stdin: stdinR,
+		calls: newCallTable(),
+	}
+	ch := make(chan []byte, 8)
+	done := make(chan struct{})
+	go func() {
+		p.runStdinToPost(context.Background(), newShutdown(), tr, ch)
+		close(done)
+	}()
+
+	waitSent := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			tr.mu.Lock()
+			sent := strings.Join(tr.sent, ",")
+			tr.mu.Unlock()
+			if sent == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("sent = %s, want %s", sent, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	fmt.Fprintln(stdinW, `{"jsonrpc":"2.0","method":"slow","id":1}`)
+	waitSent("slow")
+	fmt.Fprintln(stdinW, `{"jsonrpc":"2.0","method":"slow","id":2}`+"\n"+
+		`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":2}}`)
+	waitSent("slow,notifications/cancelled")
+	close(tr.release)
+	stdinW.Close()
+	<-done
+	close(ch)
+
+	var got []string
+	for b := range ch {
+		got = append(got, string(b))
+	}
+	if want := `{"jsonrpc":"2.0","result":{},"id":1}`; len(got) != 1 || got[0] != want {
+		t.Errorf("responses = %v, want [%s]", got, want)
+	}
+	if want := "slow,notifications/cancelled"; strings.Join(tr.sent, ",") != want {
+		t.Errorf("sent = %v, want %s (cancelled request must not reach the server)", tr.sent, want)
+	}
+	if n := p.calls.inflight(); n != 0 {
+		t.Errorf("inflight = %d, want 0", n)
+	}
+}
+
+// lateBodyTransport はリクエストの ctx を無視し、release が閉じられてから POST の応答としてレスポンスを返します
+// （キャンセルが処理された後に届くレスポンスボディの再現）。
+type lateBodyTransport struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu            sync.Mutex
+	notifications []string
+}
+
+func (l *lateBodyTransport) send(_ context.Context, msg []byte, ch chan<- []byte) error {
+	id, _, _ := member(msg, "id")
+	if id == nil {
+		l.mu.Lock()
+		l.notifications = append(l.notifications, string(msg))
+		l.mu.Unlock()
+		return nil
+	}
+	close(l.started)
+	<-l.release
+	ch <- []byte(fmt.Sprintf(`{"jsonrpc":"2.0","result":{"late":true},"id":%s}`, id))
+	return nil
+}
+
+func (l *lateBodyTransport) listen(context.Context, chan<- []byte) {}
+
+func TestProxy_cancelledNotification_lateBody(t *testing.T) {
+	stdinR, stdinW := io.Pipe()
+	var stdout bytes.Buffer
+	tr := &lateBodyTransport{started: make(chan struct{}), release: make(chan struct{})}
+	p := &Proxy{
+		cfg:    &config.Config{},
+		stdin:  stdinR,
+		stdout: &stdout,
+		calls:  newCallTable(),
+	}
+
+	ch := make(chan []byte, 8)
+	writerDone := make(chan struct{})
+	go func() {
+		p.writeStdout(ch)
+		close(writerDone)
+	}()
+	readerDone := make(chan struct{})
+	go func() {
+		p.runStdinToPost(context.Background(), newShutdown(), tr, ch)
+		close(readerDone)
+	}()
+
+	fmt.Fprintln(stdinW, `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"search_documents"},"id":42}`)
+	select {
+	case <-tr.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not sent")
+	}
+	fmt.Fprintln(stdinW, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":42}}`)
+	// キャンセルがサーバーへ転送された（表から取り除かれた）後でレスポンスを返す
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tr.mu.Lock()
+		n := len(tr.notifications)
+		tr.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cancellation was not forwarded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(tr.release)
+	stdinW.Close()
+	<-readerDone
+	close(ch)
+	<-writerDone
+
+	if stdout.Len() != 0 {
+		t.Errorf("stdout = %q, want no late response for the cancelled request", stdout.String())
+	}
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// サーバーとの通信の失敗を表す JSON-RPC エラーコードです（-32000 〜 -32099 は実装定義のサーバーエラー）。
+// クライアントが「ログインが必要」と「サーバーが落ちている」などを区別できるよう、原因ごとにコードを分けます。
+const (
+	// codeUnreachable はサーバーに接続できない（名前解決の失敗、接続拒否など）ことを表します。
+	codeUnreachable = -32001
+	// codeTLSFailure は TLS のハンドシェイクや証明書の検証に失敗したことを表します。
+	codeTLSFailure = -32002
+	// codeUnauthorized はサーバーが 401 / 403 を返した（ログインが必要、または権限が無い）ことを表します。
+	codeUnauthorized = -32003
+	// codeRateLimited はサーバーが 429 を返したことを表します。
+	codeRateLimited = -32004
+	// codeServerUnavailable はサーバーが 5xx を返したことを表します。
+	codeServerUnavailable = -32005
+	// codeTimeout はサーバーからの応答が時間内に無かったことを表します。
+	codeTimeout = -32006
+	// codeMalformedResponse はサーバーの応答が JSON として解釈できなかったことを表します。
+	codeMalformedResponse = -32007
+)
+
+// correlationHeader はリクエストごとの相関 ID を送るヘッダーです。サーバーが同じヘッダーで返した場合はそちらを使います。
+const correlationHeader = "X-Request-Id"
+
+// bodyExcerptLen は error.data に含めるレスポンスボディの抜粋の最大長（バイト）です。
+const bodyExcerptLen = 200
+
+// statusError はサーバーが想定外の HTTP ステータスを返したことを表します。
+type statusError struct {
+	code int
+	body []byte
+	// retryAfter は Retry-After ヘッダーの値
+	retryAfter string
+	// requestID はサーバーが返した相関 ID（X-Request-Id）
+	requestID string
+}
+
+// newStatusError は resp のステータスとヘッダーから statusError を作ります。
+func newStatusError(resp *http.Response, body []byte) *statusError {
+	return &statusError{
+		code:       resp.StatusCode,
+		body:       body,
+		retryAfter: resp.Header.Get("Retry-After"),
+		requestID:  resp.Header.Get(correlationHeader),
+	}
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server error: status %d: %s", e.code, string(e.body))
+}
+
+// malformedError はサーバーの応答が JSON として解釈できなかったことを表します。
+type malformedError struct {
+	body []byte
+}
+
+func (e *malformedError) Error() string {
+	return fmt.Sprintf("malformed JSON from server: %s", excerpt(e.body))
+}
+
+// timeoutError はリクエストが設定のタイムアウトまでにレスポンスを得られなかったことを表します。
+type timeoutError struct {
+	method string
+	after  time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.method, e.after)
+}
+
+func (e *timeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// correlatedError は err に、そのリクエストに付けた相関 ID を添えます。
+type correlatedError struct {
+	id  string
+	err error
+}
+
+func (e *correlatedError) Error() string { return e.err.Error() }
+func (e *correlatedError) Unwrap() error { return e.err }
+
+// correlationKey は相関 ID を context に載せるためのキーです。
+type correlationKey struct{}
+
+// withCorrelationID は POST に付ける相関 ID を ctx に載せます。
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// correlationID は ctx に載った相関 ID を返します。無い場合は空文字列を返します。
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// newCorrelationID はランダムな相関 ID を返します。
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// rpcError は JSON-RPC の error オブジェクトです。
+type rpcError struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Data    *errorData `json:"data,omitempty"`
+}
+
+// errorData はサーバーとの通信の失敗について error.data に含める情報です。
+type errorData struct {
+	// HTTPStatus はサーバーが返した HTTP ステータス
+	HTTPStatus int `json:"httpStatus,omitempty"`
+	// RetryAfter は再試行まで待つべき秒数（Retry-After ヘッダー）
+	RetryAfter *int `json:"retryAfter,omitempty"`
+	// CorrelationID はサーバーのログと突き合わせるための相関 ID
+	CorrelationID string `json:"correlationId,omitempty"`
+	// BodyExcerpt はレスポンスボディの先頭の抜粋
+	BodyExcerpt string `json:"bodyExcerpt,omitempty"`
+}
+
+// classifyError は err を JSON-RPC のエラーに変換します。
+func classifyError(err error) rpcError {
+	data := &errorData{}
+	var ce *correlatedError
+	if errors.As(err, &ce) {
+		data.CorrelationID = ce.id
+	}
+
+	e := rpcError{Code: codeInternalError, Message: err.Error(), Data: data}
+	var (
+		se   *statusError
+		me   *malformedError
+		te   *timeoutError
+		ne   net.Error
+		cve  *tls.CertificateVerificationError
+		uae  x509.UnknownAuthorityError
+		hne  x509.HostnameError
+		cie  x509.CertificateInvalidError
+		rhe  tls.RecordHeaderError
+		alrt tls.AlertError
+	)
+	switch {
+	case errors.As(err, &se):
+		data.HTTPStatus = se.code
+		data.BodyExcerpt = excerpt(se.body)
+		if se.requestID != "" {
+			data.CorrelationID = se.requestID
+		}
+		if s, ok := retryAfterSeconds(se.retryAfter, time.Now()); ok {
+			data.RetryAfter = &s
+		}
+		switch {
+		case se.code == http.StatusUnauthorized:
+			e.Code, e.Message = codeUnauthorized, "Authentication required: run `mcp-bridge login` and try again"
+		case se.code == http.StatusForbidden:
+			e.Code, e.Message = codeUnauthorized, "Access denied: the signed-in user is not allowed to use this MCP server"
+		case se.code == http.StatusTooManyRequests:
+			e.Code, e.Message = codeRateLimited, "Rate limited by the MCP server, try again later"
+		case se.code >= 500:
+			e.Code, e.Message = codeServerUnavailable, fmt.Sprintf("MCP server is unavailable (HTTP %d)", se.code)
+		case errors.Is(err, errSessionExpired):
+			e.Message = "MCP session expired, the client must re-initialize"
+		default:
+			e.Message = fmt.Sprintf("MCP server returned HTTP %d", se.code)
+		}
+	case errors.As(err, &me):
+		data.BodyExcerpt = excerpt(me.body)
+		e.Code, e.Message = codeMalformedResponse, "Malformed JSON in the response from the MCP server"
+	case errors.As(err, &te):
+		e.Code, e.Message = codeTimeout, fmt.Sprintf("Timed out after %s waiting for the MCP server to respond to %s", te.after, te.method)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		e.Code, e.Message = codeTimeout, "Timed out waiting for the MCP server"
+	case errors.As(err, &cve), errors.As(err, &uae), errors.As(err, &hne), errors.As(err, &cie),
+		errors.As(err, &rhe), errors.As(err, &alrt):
+		e.Code, e.Message = codeTLSFailure, "TLS connection to the MCP server failed: "+rootCause(err)
+	case errors.As(err, &ne):
+		e.Code, e.Message = codeUnreachable, "Cannot reach the MCP server: "+rootCause(err)
+	}
+	if *data == (errorData{}) {
+		e.Data = nil
+	}
+	return e
+}
+
+// failureResponse は err を分類したエラーレスポンスを id 宛てに返します。
+func failureResponse(id json.RawMessage, err error) []byte {
+	return rpcErrorResponse(id, classifyError(err))
+}
+
+// retryAfterSeconds は Retry-After ヘッダーの値（秒数または HTTP 日付）を now からの秒数に変換します。
+func retryAfterSeconds(v string, now time.Time) (int, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return s, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(0, int(t.Sub(now).Round(time.Second)/time.Second)), true
+}
+
+// excerpt はレスポンスボディの先頭 bodyExcerptLen バイトを、空白を詰めた 1 行にして返します。
+func excerpt(body []byte) string {
+	s := strings.Join(strings.Fields(string(body)), " ")
+	if len(s) <= bodyExcerptLen {
+		return s
+	}
+	cut := bodyExcerptLen
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// rootCause は err を最後まで Unwrap したエラーのメッセージを返します。
+func rootCause(err error) string {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err.Error()
+		}
+		err = next
+	}
+}
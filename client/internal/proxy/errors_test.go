@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+)
+
+func TestClassifyError(t *testing.T) {
+	// 実際の通信エラーを得るためのサーバー
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	_, tlsErr := http.Get(tlsServer.URL)
+	_, refusedErr := http.Get(closedURL)
+	timeoutClient := &http.Client{Timeout: time.Nanosecond}
+	_, timeoutErr := timeoutClient.Get(tlsServer.URL)
+	html := []byte("<html>\n  <body>Bad   Gateway</body>\n</html>")
+
+	seven := 7
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantMsg  string
+		wantData *errorData
+	}{
+		{
+			name:     "401",
+			err:      &statusError{code: 401, body: []byte(`{"message":"Unauthorized"}`)},
+			wantCode: codeUnauthorized,
+			wantMsg:  "mcp-bridge login",
+			wantData: &errorData{HTTPStatus: 401, BodyExcerpt: `{"message":"Unauthorized"}`},
+		},
+		{
+			name:     "403",
+			err:      &statusError{code: 403},
+			wantCode: codeUnauthorized,
+			wantMsg:  "Access denied",
+			wantData: &errorData{HTTPStatus: 403},
+		},
+		{
+			name:     "429 with retry-after",
+			err:      &correlatedError{id: "ours", err: &statusError{code: 429, retryAfter: "7"}},
+			wantCode: codeRateLimited,
+			wantMsg:  "Rate limited",
+			wantData: &errorData{HTTPStatus: 429, RetryAfter: &seven, CorrelationID: "ours"},
+		},
+		{
+			name:     "502 html",
+			err:      &correlatedError{id: "ours", err: &statusError{code: 502, body: html, requestID: "server-id"}},
+			wantCode: codeServerUnavailable,
+			wantMsg:  "HTTP 502",
+			wantData: &errorData{HTTPStatus: 502, CorrelationID: "server-id", BodyExcerpt: "<html> <body>Bad Gateway</body> </html>"},
+		},
+		{
+			name:     "session expired",
+			err:      fmt.Errorf("%w: %w", errSessionExpired, &statusError{code: 404}),
+			wantCode: codeInternalError,
+			wantMsg:  "session expired",
+			wantData: &errorData{HTTPStatus: 404},
+		},
+		{
+			name:     "other status",
+			err:      &statusError{code: 400, body: []byte("bad")},
+			wantCode: codeInternalError,
+			wantMsg:  "HTTP 400",
+			wantData: &errorData{HTTPStatus: 400, BodyExcerpt: "bad"},
+		},
+		{
+			name:     "malformed JSON",
+			err:      &malformedError{body: []byte("<html>oops")},
+			wantCode: codeMalformedResponse,
+			wantMsg:  "Malformed JSON",
+			wantData: &errorData{BodyExcerpt: "<html>oops"},
+		},
+		{
+			name:     "context deadline",
+			err:      fmt.Errorf("post request: %w", context.DeadlineExceeded),
+			wantCode: codeTimeout,
+			wantMsg:  "Timed out",
+		},
+		{
+			name:     "client timeout",
+			err:      timeoutErr,
+			wantCode: codeTimeout,
+			wantMsg:  "Timed out",
+		},
+		{
+			name:     "TLS",
+			err:      tlsErr,
+			wantCode: codeTLSFailure,
+			wantMsg:  "TLS connection",
+		},
+		{
+			name:     "connection refused",
+			err:      &correlatedError{id: "ours", err: refusedErr},
+			wantCode: codeUnreachable,
+			wantMsg:  "Cannot reach",
+			wantData: &errorData{CorrelationID: "ours"},
+		},
+		{
+			name:     "other",
+			err:      errors.New("boom"),
+			wantCode: codeInternalError,
+			wantMsg:  "boom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Fatal("test setup did not produce an error")
+			}
+			got := classifyError(tt.err)
+			if got.Code != tt.wantCode {
+				t.Errorf("Code = %d, want %d (err %v)", got.Code, tt.wantCode, tt.err)
+			}
+			if !strings.Contains(got.Message, tt.wantMsg) {
+				t.Errorf("Message = %q, want containing %q", got.Message, tt.wantMsg)
+			}
+			gotData, _ := json.Marshal(got.Data)
+			wantData, _ := json.Marshal(tt.wantData)
+			if string(gotData) != string(wantData) {
+				t.Errorf("Data = %s, want %s", gotData, wantData)
+			}
+		})
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in     string
+		want   int
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 120, true},
+		{"-1", 0, false},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfterSeconds(tt.in, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("retryAfterSeconds(%q) = %d, %v, want %d, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	long := strings.Repeat("あ", 100)
+	got := excerpt([]byte(long))
+	if !strings.HasSuffix(got, "...") || len(got) > bodyExcerptLen+3 || !strings.HasPrefix(long, strings.TrimSuffix(got, "...")) {
+		t.Errorf("excerpt() = %q, want a prefix cut at a rune boundary", got)
+	}
+}
+
+func TestProxy_runStdinToPost_errorData(t *testing.T) {
+	var gotID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get(correlationHeader)
+		w.Header().Set("Retry-After", "3")
+		http.Error(w, "<html>upstream down</html>", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	p := &Proxy{
+		cfg:    &config.Config{URL: srv.URL},
+		client: srv.Client(),
+		stdin:  strings.NewReader(`{"jsonrpc":"2.0","method":"tools/call","id":"c1"}` + "\n"),
+		calls:  newCallTable(),
+	}
+	ch := make(chan []byte, 1)
+	p.runStdinToPost(context.Background(), newShutdown(), newStreamableTransport(p), ch)
+
+	var resp struct {
+		ID    string   `json:"id"`
+		Error rpcError `json:"error"`
+	}
+	if err := json.Unmarshal(<-ch, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "c1" || resp.Error.Code != codeServerUnavailable || resp.Error.Data == nil {
+		t.Fatalf("response = %+v", resp)
+	}
+	d := resp.Error.Data
+	if gotID == "" || d.CorrelationID != gotID {
+		t.Errorf("correlationId = %q, want the X-Request-Id sent to the server (%q)", d.CorrelationID, gotID)
+	}
+	if d.HTTPStatus != 503 || d.RetryAfter == nil || *d.RetryAfter != 3 || d.BodyExcerpt != "<html>upstream down</html>" {
+		t.Errorf("data = %+v", d)
+	}
+}
+
+func TestProxy_runStdinToPost_malformedResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"jsonrpc":"2.0","result":`)
+	}))
+	defer srv.Close()
+
+	p := &Proxy{
+		cfg:    &config.Config{URL: srv.URL},
+		client: srv.Client(),
+		stdin:  strings.NewReader(`{"jsonrpc":"2.0","method":"ping","id":1}` + "\n"),
+		calls:  newCallTable(),
+	}
+	ch := make(chan []byte, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p.runStdinToPost(ctx, newShutdown(), newStreamableTransport(p), ch)
+
+	select {
+	case got := <-ch:
+		if !strings.Contains(string(got), fmt.Sprintf(`"code":%d`, codeMalformedResponse)) {
+			t.Errorf("response = %s, want a malformed response error", got)
+		}
+	default:
+		t.Fatal("no response")
+	}
+}
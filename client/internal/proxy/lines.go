@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// headSize は上限を超えたメッセージのうち、id を探すために残す先頭部分の大きさです。
+const headSize = 64 << 10
+
+// lineReader は改行区切りの JSON-RPC メッセージを 1 行ずつ読みます。
+// bufio.Scanner と違い、上限を超えた行があっても読み込みを続けられます。
+type lineReader struct {
+	r *bufio.Reader
+	// limit は 1 行（改行を除く）の大きさの上限
+	limit int
+}
+
+func newLineReader(r io.Reader, limit int) *lineReader {
+	return &lineReader{r: bufio.NewReader(r), limit: limit}
+}
+
+// next は次の行を改行（LF または CRLF）を除いて返します。
+// 行が limit を超える場合は残りを読み捨て、先頭の最大 headSize バイトを返して tooLong に true を返します。
+// 最後の行は改行で終わっていなくても返します。読む行が無くなると io.EOF を返します。
+func (l *lineReader) next() (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := l.r.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(trimEOL(line)) > l.limit {
+				tooLong = true
+				line = line[:min(len(line), headSize, l.limit)]
+			}
+		}
+
+		switch {
+		case err == nil:
+			if tooLong {
+				return line, true, nil
+			}
+			return trimEOL(line), false, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && (len(line) > 0 || tooLong):
+			if tooLong {
+				return line, true, nil
+			}
+			return trimEOL(line), false, nil
+		default:
+			return nil, false, err
+		}
+	}
+}
+
+// trimEOL は行末の LF または CRLF を取り除きます。
+func trimEOL(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
+
+// leadingID は途中で切れた JSON-RPC メッセージの先頭部分から id を探します。
+// id が params などの大きな値より前にある場合にだけ見つかります。見つからない場合は ok に false を返します。
+func leadingID(head []byte) (id json.RawMessage, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(head))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, false
+		}
+		if tok == "id" {
+			// 数値は末尾で切れていると桁が欠けている可能性があるので、後ろに続きがある場合だけ採用する
+			if int(dec.InputOffset()) >= len(head) {
+				return nil, false
+			}
+			return raw, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLineReader_next(t *testing.T) {
+	type line struct {
+		text    string
+		tooLong bool
+	}
+	tests := []struct {
+		name  string
+		input string
+		limit int
+		want  []line
+	}{
+		{"lf and crlf", "a\r\nbb\n\nc", 10, []line{{"a", false}, {"bb", false}, {"", false}, {"c", false}}},
+		{"exactly the limit", "abcd\nabcde\n", 4, []line{{"abcd", false}, {"abcd", true}}},
+		{"continues after an oversized line", strings.Repeat("x", 10000) + "\nok\n", 100, []line{{strings.Repeat("x", 100), true}, {"ok", false}}},
+		{"oversized last line without newline", "ok\n" + strings.Repeat("y", 50), 10, []line{{"ok", false}, {strings.Repeat("y", 10), true}}},
+		{"larger than the bufio buffer", strings.Repeat("z", 100000) + "\n", 200000, []line{{strings.Repeat("z", 100000), false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range []io.Reader{strings.NewReader(tt.input), iotest.HalfReader(strings.NewReader(tt.input))} {
+				lr := newLineReader(r, tt.limit)
+				var got []line
+				for {
+					b, tooLong, err := lr.next()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					if err != nil {
+						t.Fatalf("next() error = %v", err)
+					}
+					got = append(got, line{string(b), tooLong})
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("lines = %d, want %d", len(got), len(tt.want))
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Errorf("line[%d] = %.20q (tooLong %v), want %.20q (tooLong %v)", i, got[i].text, got[i].tooLong, tt.want[i].text, tt.want[i].tooLong)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLeadingID(t *testing.T) {
+	tests := []struct {
+		head   string
+		want   string
+		wantOK bool
+	}{
+		{`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"arguments":{"q":"xxxx`, "5", true},
+		{`{"jsonrpc":"2.0","method":"tools/call","id":"a-1","params":{"argu`, `"a-1"`, true},
+		{`{"jsonrpc":"2.0","method":"tools/call","params":{"arguments":{"q":"xxxx`, "", false},
+		{`{"jsonrpc":"2.0","id":12345`, "", false},
+		{`[{"jsonrpc":"2.0","id":1`, "", false},
+		{`not json`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.head, func(t *testing.T) {
+			got, ok := leadingID([]byte(tt.head))
+			if ok != tt.wantOK || string(got) != tt.want {
+				t.Errorf("leadingID() = %s, %v, want %s, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// JSON-RPC 2.0 の標準エラーコードです。
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeInternalError  = -32603
+)
+
+// batchProtocolVersion は JSON-RPC バッチに対応している MCP のプロトコルバージョンです。
+const batchProtocolVersion = "2025-03-26"
+
+// messageKind は JSON-RPC メッセージの種類です。
+type messageKind int
+
+const (
+	// kindInvalid は JSON として解析できない、または JSON-RPC メッセージとして解釈できないものです。
+	kindInvalid messageKind = iota
+	// kindRequest は method と id を持ち、レスポンスを返す必要があるリクエストです。
+	kindRequest
+	// kindNotification は id を持たないリクエストで、レスポンスを返してはいけません。
+	kindNotification
+	// kindResponse は result または error を持つレスポンスです（サーバーからのリクエストに対するクライアントの応答など）。
+	kindResponse
+	// kindBatch は JSON 配列（バッチ）です。
+	kindBatch
+)
+
+func (k messageKind) String() string {
+	switch k {
+	case kindRequest:
+		return "request"
+	case kindNotification:
+		return "notification"
+	case kindResponse:
+		return "response"
+	case kindBatch:
+		return "batch"
+	default:
+		return "invalid"
+	}
+}
+
+// classify は msg の種類を判定します。
+func classify(msg []byte) messageKind {
+	trimmed := bytes.TrimSpace(msg)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if !json.Valid(trimmed) {
+			return kindInvalid
+		}
+		return kindBatch
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &m); err != nil || m == nil {
+		return kindInvalid
+	}
+	_, hasMethod := m["method"]
+	_, hasID := m["id"]
+	_, hasResult := m["result"]
+	_, hasError := m["error"]
+	switch {
+	case hasMethod && hasID:
+		return kindRequest
+	case hasMethod:
+		return kindNotification
+	case hasResult || hasError:
+		return kindResponse
+	default:
+		return kindInvalid
+	}
+}
+
+// errorResponse は id 宛ての JSON-RPC エラーレスポンスを返します。id が nil の場合は null になります。
+func errorResponse(id json.RawMessage, code int, message string) []byte {
+	return rpcErrorResponse(id, rpcError{Code: code, Message: message})
+}
+
+// rpcErrorResponse は error オブジェクト e を持つ id 宛ての JSON-RPC エラーレスポンスを返します。
+func rpcErrorResponse(id json.RawMessage, e rpcError) []byte {
+	body, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"error":   e,
+		"id":      id,
+	})
+	return body
+}
+
+// invalidResponse は JSON-RPC として解釈できない msg に返すエラーレスポンスです（JSON-RPC 2.0 5.1）。
+// id を特定できない場合は仕様どおり null にします。
+func invalidResponse(msg []byte) []byte {
+	if !json.Valid(msg) {
+		return errorResponse(nil, codeParseError, "Parse error")
+	}
+	id, _, _ := member(msg, "id")
+	return errorResponse(id, codeInvalidRequest, "Invalid Request")
+}
+
+// splitBatch は msg がバッチ（配列）であれば要素ごとに分け、そうでなければ msg だけを返します。
+func splitBatch(msg []byte) [][]byte {
+	if classify(msg) != kindBatch {
+		return [][]byte{msg}
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(msg, &elems); err != nil {
+		return nil
+	}
+	out := make([][]byte, len(elems))
+	for i, el := range elems {
+		out[i] = el
+	}
+	return out
+}
+
+// joinBatch は nil でないメッセージを順に並べたバッチ（配列）を返します。すべて nil の場合は nil を返します。
+func joinBatch(msgs [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, m := range msgs {
+		if m == nil {
+			continue
+		}
+		if buf.Len() == 0 {
+			buf.WriteByte('[')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.Write(m)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// toolName は tools/call リクエストの params.name（呼び出すツール名）を返します。それ以外のメッセージでは空文字列を返します。
+func toolName(msg []byte) string {
+	var m struct {
+		Method string `json:"method"`
+		Params struct {
+			Name string `json:"name"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil || m.Method != "tools/call" {
+		return ""
+	}
+	return m.Params.Name
+}
+
+// protocolVersion は initialize レスポンスの result.protocolVersion（サーバーと合意したプロトコルバージョン）を返します。
+// 取り出せない場合は空文字列を返します。
+func protocolVersion(resp []byte) string {
+	var m struct {
+		Result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &m); err != nil {
+		return ""
+	}
+	return m.Result.ProtocolVersion
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want messageKind
+	}{
+		{"request", `{"jsonrpc":"2.0","method":"tools/list","id":1}`, kindRequest},
+		{"request with string id", `{"jsonrpc":"2.0","method":"ping","id":"a"}`, kindRequest},
+		{"request with null id", `{"jsonrpc":"2.0","method":"ping","id":null}`, kindRequest},
+		{"notification", `{"jsonrpc":"2.0","method":"notifications/initialized"}`, kindNotification},
+		{"cancel notification", `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}`, kindNotification},
+		{"result response", `{"jsonrpc":"2.0","result":{},"id":5}`, kindResponse},
+		{"error response", `{"jsonrpc":"2.0","error":{"code":-1,"message":"x"},"id":5}`, kindResponse},
+		{"batch", `[{"jsonrpc":"2.0","method":"ping","id":1}]`, kindBatch},
+		{"batch with leading space", ` [ ] `, kindBatch},
+		{"broken json", `{"jsonrpc":`, kindInvalid},
+		{"broken batch", `[{"jsonrpc":`, kindInvalid},
+		{"no method or result", `{"jsonrpc":"2.0","id":1}`, kindInvalid},
+		{"scalar", `42`, kindInvalid},
+		{"null", `null`, kindInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify([]byte(tt.msg)); got != tt.want {
+				t.Errorf("classify(%s) = %v, want %v", tt.msg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		resp string
+		want string
+	}{
+		{"initialize result", `{"jsonrpc":"2.0","result":{"protocolVersion":"2025-03-26"},"id":1}`, "2025-03-26"},
+		{"no protocolVersion", `{"jsonrpc":"2.0","result":{},"id":1}`, ""},
+		{"error response", `{"jsonrpc":"2.0","error":{"code":-32602,"message":"x"},"id":1}`, ""},
+		{"broken json", `{"jsonrpc":`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := protocolVersion([]byte(tt.resp)); got != tt.want {
+				t.Errorf("protocolVersion(%s) = %q, want %q", tt.resp, got, tt.want)
+			}
+		})
+	}
+}
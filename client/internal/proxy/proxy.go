@@ -1,10 +1,10 @@
 package proxy
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,300 +12,863 @@ import (
 	"sync"
 	"time"
 
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/auth"
 	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/sse"
 )
 
-// Proxy はstdioとMCPサーバー（SSE + POST）の間でJSON-RPCを中継します。
+// Proxy はstdioとMCPサーバーの間でJSON-RPCを中継します。
+// サーバーとの通信方式（旧来の SSE + POST / Streamable HTTP）は transport が担います。
 type Proxy struct {
 	cfg    *config.Config
 	client *http.Client
+	// tokens はプロファイルのアクセストークンの提供元。nil の場合は認証ヘッダーを付与しない。
+	tokens *auth.Source
+	// stdin / stdout はクライアント（Claude Desktop）との入出力。New では os.Stdin / os.Stdout を使う。
+	stdin  io.Reader
+	stdout io.Writer
+	// calls は実行中のリクエストと、サーバーへ送る際に割り当てた id の表
+	calls *callTable
+
+	// retryBase は SSE ストリームの再接続の最初の待ち時間。0 の場合は defaultRetryBase を使う（テスト用）。
+	retryBase time.Duration
+
+	// cancels はタイムアウトしたリクエストのキャンセルをサーバーへ伝えている goroutine。終了時に待つ。
+	cancels sync.WaitGroup
+
+	mu sync.Mutex
+	// protocolVersion は initialize でサーバーと合意した MCP のプロトコルバージョン
+	protocolVersion string
 }
 
 // New はProxyを生成します。
 func New(cfg *config.Config) *Proxy {
-	return &Proxy{
+	p := &Proxy{
 		cfg: cfg,
 		client: &http.Client{
-			Timeout:   0, // POSTはストリームなのでタイムアウトなし
+			Timeout:   0, // SSE の GET は長時間開いたままなので、タイムアウトはリクエストごとに ctx で設定する
 			Transport: &http.Transport{},
 		},
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		calls:  newCallTable(),
 	}
+	tokens, err := auth.NewSource(cfg)
+	if err != nil {
+		p.debugf("token store unavailable: %v", err)
+	} else {
+		p.tokens = tokens
+	}
+	return p
 }
 
+// stdoutBuffer は stdout 用チャネルの容量です。
+const stdoutBuffer = 32
+
 // Run はプロキシを開始します。
-// Goroutine A: stdin から JSON-RPC を読み、サーバーへ POST し、レスポンスを stdout 用チャネルへ送る。
-// Goroutine B: GET /sse でイベントを受信し、イベントデータを stdout 用チャネルへ送る。
-// 単一の writer がチャネルから取り出して os.Stdout に書き込みます。
+// Goroutine A: stdin から JSON-RPC を読み、transport でサーバーへ送り、レスポンスを stdout 用チャネルへ送る。
+// Goroutine B: transport のサーバー→クライアント方向のストリームを受信し、届いたメッセージを dispatch に渡す。
+// 単一の writer がチャネルから取り出して stdout に書き込みます。
+// チャネルへの送信は捨てずに待つため、stdout の書き込みが遅いと stdin の読み込みも待たされます。
+// 溜まるメッセージは stdoutBuffer 件と実行中のリクエスト（MaxConcurrency 件）の分までに収まります。
+//
+// stdin が閉じられるか ctx が終了すると、新しい入力の受け付けを止め、実行中のリクエストのレスポンスを
+// ShutdownGrace まで待って stdout に書き出してから、サーバーとのストリームを閉じて戻ります。
+// stdin が閉じられて全てのレスポンスを返せた場合は nil、ctx の終了がきっかけの場合は ctx.Err()、
+// 猶予時間内に終わらなかったリクエストを打ち切った場合は ErrDrainTimeout を返します。
 func (p *Proxy) Run(ctx context.Context) error {
-	baseURL := p.cfg.BaseURL()
-	mcpURL := baseURL + p.cfg.McpPath()
-	sseURL := p.cfg.URL
+	tr, err := p.newTransport()
+	if err != nil {
+		return err
+	}
+	return p.run(ctx, tr)
+}
 
-	toStdout := make(chan []byte, 32)
-	var wg sync.WaitGroup
+// run は tr を使って Run の処理を行います。
+func (p *Proxy) run(ctx context.Context, tr transport) error {
+	// 実行中のリクエストとストリームは ctx が終わった後も猶予時間の間は続けるので、ctx のキャンセルは引き継がない
+	work, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+	listenCtx, stopListen := context.WithCancel(work)
+	defer stopListen()
 
-	// Goroutine A: stdin → POST /mcp → toStdout
-	wg.Add(1)
+	sd := newShutdown()
 	go func() {
-		defer wg.Done()
-		p.runStdinToPost(ctx, mcpURL, toStdout)
+		select {
+		case <-ctx.Done():
+			sd.begin(ctx.Err())
+		case <-sd.begun:
+		}
 	}()
 
-	// Goroutine B: GET /sse → event data → toStdout
-	wg.Add(1)
+	toStdout := make(chan []byte, stdoutBuffer)
+
+	// Goroutine A: stdin → transport.send → toStdout
+	drained := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		p.runSSEReceiver(ctx, sseURL, toStdout)
+		defer close(drained)
+		p.runStdinToPost(work, sd, tr, toStdout)
 	}()
 
-	// stdout writer: toStdout を os.Stdout に書き込む
+	// Goroutine B: transport.listen → dispatch → toStdout
+	listened := make(chan struct{})
 	go func() {
-		for b := range toStdout {
-			if _, err := os.Stdout.Write(b); err != nil {
-				if p.cfg.Debug {
-					fmt.Fprintf(os.Stderr, "[proxy] stdout write error: %v\n", err)
-				}
-				return
-			}
-			if len(b) > 0 && b[len(b)-1] != '\n' {
-				os.Stdout.Write([]byte("\n"))
-			}
+		defer close(listened)
+		incoming := make(chan []byte, 32)
+		go func() {
+			tr.listen(listenCtx, incoming)
+			close(incoming)
+		}()
+		for msg := range incoming {
+			p.dispatch(listenCtx, msg, toStdout)
 		}
 	}()
 
-	// 両方のgoroutineが終了したら toStdout を閉じる
+	// stdout writer: toStdout を stdout に書き込む
+	written := make(chan struct{})
 	go func() {
-		wg.Wait()
-		close(toStdout)
+		defer close(written)
+		p.writeStdout(toStdout)
 	}()
 
-	<-ctx.Done()
-	return ctx.Err()
+	<-sd.begun
+	err := sd.reason
+	grace, cancelGrace := context.WithTimeout(context.WithoutCancel(ctx), p.cfg.ShutdownGraceOrDefault())
+	defer cancelGrace()
+	p.debugf("shutting down (%v), waiting for %d in-flight requests", shutdownCause(err), p.calls.inflight())
+
+	select {
+	case <-drained:
+	case <-grace.Done():
+		n := p.calls.inflight()
+		p.debugf("shutdown grace period expired, abandoning %d requests", n)
+		cancelWork()
+		<-drained
+		err = fmt.Errorf("%w (%d requests abandoned)", ErrDrainTimeout, n)
+	}
+
+	// レスポンスを待つ必要が無くなったのでストリームを閉じ、セッションを終了する
+	p.cancels.Wait()
+	stopListen()
+	if c, ok := tr.(sessionCloser); ok {
+		closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sessionCloseTimeout)
+		c.closeSession(closeCtx)
+		cancel()
+	}
+	<-listened
+
+	// 書き残したレスポンスを stdout へ書き出す。stdout が詰まっている場合は猶予時間で諦める
+	close(toStdout)
+	select {
+	case <-written:
+	case <-grace.Done():
+		p.debugf("stdout did not drain within the shutdown grace period")
+	}
+	return err
 }
 
-// runStdinToPost は標準入力から JSON-RPC を1行ずつ読み、サーバーに POST し、レスポンスを ch に送ります。
-func (p *Proxy) runStdinToPost(ctx context.Context, mcpURL string, ch chan<- []byte) {
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Buffer(nil, 1024*1024) // 1MB max per line
+// shutdownCause は終了のきっかけをログ用の文字列にします。
+func shutdownCause(reason error) string {
+	if reason == nil {
+		return "stdin closed"
+	}
+	return reason.Error()
+}
 
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
-		requestID := extractRequestID(line)
+// runStdinToPost は標準入力から JSON-RPC を1行ずつ読み、transport でサーバーに送り、レスポンスを ch に送ります。
+// リクエストはそれぞれ別の goroutine で送り、同時実行数は MaxConcurrency で制限します。
+// 上限を超えたリクエストは maxQueuedRequests 件まで空きを待たせ、その間も stdin の読み込みを続けます
+// （ping や notifications/cancelled が遅いリクエストの後ろで待たされないようにする）。待ちきれないほど溜まった場合だけ stdin の読み込みを待たせます。
+// 通知などレスポンスを待たないメッセージは受け取った順に 1 つの goroutine で送り、順序を保ちます。
+// バッチはリクエストと同様に 1 件として扱い、レスポンスを 1 つの配列にまとめて返します。
+// 通知に対してはエラーを含め何も返しません（JSON-RPC 2.0 仕様）。
+// 1 行が MaxMessageBytes を超えるメッセージにはエラーを返し、次の行から読み込みを続けます。
+// stdin が終わると sd の終了処理を始めます。sd の終了処理が始まると新しい入力を受け付けず、
+// 実行中のリクエストが終わる（または ctx が終了する）のを待って戻ります。
+func (p *Proxy) runStdinToPost(ctx context.Context, sd *shutdown, tr transport, ch chan<- []byte) {
+	lines := p.readLines(sd)
 
-		// 空でない行をそのまま JSON-RPC リクエストとして送る
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, mcpURL, bytes.NewReader(line))
-		if err != nil {
-			p.sendError(ch, requestID, "build request", err)
-			continue
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ordered := make(chan []byte, 32)
+	defer close(ordered)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for msg := range ordered {
+			p.sendOneWay(ctx, tr, msg, ch)
 		}
-		req.Header.Set("Content-Type", "application/json")
-		p.addAuthHeader(req)
+	}()
 
-		resp, err := p.client.Do(req)
-		if err != nil {
-			p.sendError(ch, requestID, "post request", err)
+	limit := p.cfg.MaxConcurrencyOrDefault()
+	// sem は送信中のリクエスト、queue は送信中と空きを待っているリクエストの数を制限する
+	sem := make(chan struct{}, limit)
+	queue := make(chan struct{}, limit+maxQueuedRequests)
+	for {
+		var l stdinLine
+		select {
+		case l = <-lines:
+		case <-sd.begun:
+			return
+		case <-ctx.Done():
+			return
+		}
+		if l.err != nil {
+			if l.err != io.EOF {
+				p.debugf("stdin read error: %v", l.err)
+			}
+			sd.begin(nil)
+			return
+		}
+		msg := l.msg
+		if l.tooLong {
+			p.rejectTooLarge(ctx, ch, msg)
 			continue
 		}
-
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			p.sendError(ch, requestID, "read response", err)
+		if len(bytes.TrimSpace(msg)) == 0 {
 			continue
 		}
 
-		if resp.StatusCode != http.StatusOK {
-			if p.cfg.Debug {
-				fmt.Fprintf(os.Stderr, "[proxy] POST %s status=%d body=%s\n", mcpURL, resp.StatusCode, string(body))
+		kind := classify(msg)
+		switch kind {
+		case kindInvalid:
+			p.rejectInvalid(ctx, ch, msg)
+			continue
+		case kindNotification, kindResponse:
+			// レスポンスはサーバーからのリクエストへの応答で、そのままサーバーへ返す。
+			// notifications/cancelled は実行中の HTTP リクエストを止め、サーバーには割り当てた id でキャンセルを伝える
+			out, ok := p.prepareOneWay(msg)
+			if !ok {
+				continue
+			}
+			select {
+			case ordered <- out:
+			case <-ctx.Done():
+				return
 			}
-			p.sendError(ch, requestID, "server error", fmt.Errorf("status %d: %s", resp.StatusCode, string(body)))
 			continue
 		}
 
-		body = normalizeResponseID(body)
 		select {
-		case ch <- body:
+		case queue <- struct{}{}:
 		case <-ctx.Done():
 			return
 		}
+		// calls への登録は読み込んだ順にここで行い、直後に届く notifications/cancelled が
+		// 空きを待っているリクエストも確実に見つけられるようにする
+		var (
+			wait <-chan struct{}
+			run  func()
+		)
+		if kind == kindBatch {
+			b, err := p.acceptBatch(ctx, msg)
+			if err != nil {
+				<-queue
+				p.rejectInvalid(ctx, ch, msg)
+				continue
+			}
+			wait = ctx.Done()
+			run = func() { p.completeBatch(ctx, tr, b, ch) }
+		} else {
+			r, err := p.acceptRequest(ctx, msg)
+			if err != nil {
+				<-queue
+				p.debugf("map request id: %v", err)
+				send(ctx, ch, invalidResponse(msg))
+				continue
+			}
+			// キャンセルやタイムアウトで reqCtx が終了したリクエストは空きを待たずに片付ける
+			wait = r.reqCtx.Done()
+			run = func() {
+				if resp, ok := p.complete(ctx, tr, r, ch); ok {
+					send(ctx, ch, resp)
+				}
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-queue }()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-wait:
+			}
+			run()
+		}()
 	}
+}
 
-	if err := scanner.Err(); err != nil && err != io.EOF && p.cfg.Debug {
-		fmt.Fprintf(os.Stderr, "[proxy] stdin scan error: %v\n", err)
-	}
+// maxQueuedRequests は同時実行数の上限に達したときに、空きを待たせておけるリクエストの数です。
+const maxQueuedRequests = 256
+
+// stdinLine は stdin から読んだ 1 行です。err が nil でない場合は読み込みの終わりを表します。
+type stdinLine struct {
+	msg     []byte
+	tooLong bool
+	err     error
 }
 
-// runSSEReceiver は GET /sse でストリームを受け、各イベントの data を ch に送ります。
-func (p *Proxy) runSSEReceiver(ctx context.Context, sseURL string, ch chan<- []byte) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
+// readLines は stdin を別の goroutine で 1 行ずつ読み、返すチャネルに送ります。
+// stdin の Read は中断できないので、sd の終了処理が始まった後は読み込み途中の行を捨てて goroutine を終えます。
+// チャネルに容量は無く、受け取られるまで次の行を読まない（stdin のバックプレッシャー）。
+func (p *Proxy) readLines(sd *shutdown) <-chan stdinLine {
+	r := newLineReader(p.stdin, p.cfg.MaxMessageBytesOrDefault())
+	lines := make(chan stdinLine)
+	go func() {
+		for {
+			msg, tooLong, err := r.next()
+			select {
+			case lines <- stdinLine{msg: msg, tooLong: tooLong, err: err}:
+			case <-sd.begun:
+				return
+			}
+			if err != nil {
+				return
+			}
 		}
+	}()
+	return lines
+}
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, sseURL, nil)
-		if err != nil {
-			if p.cfg.Debug {
-				fmt.Fprintf(os.Stderr, "[proxy] SSE request build error: %v\n", err)
-			}
-			time.Sleep(2 * time.Second)
+// translateCancel はクライアントの id が clientKey のリクエストをキャンセルし、
+// サーバーへ転送する notifications/cancelled の requestId をブリッジが割り当てた id に置き換えます。
+// 該当するリクエストが既に完了している場合、サーバーに伝えることはないので ok に false を返します。
+func (p *Proxy) translateCancel(clientKey string, msg []byte) ([]byte, bool) {
+	bridgeID, ok := p.calls.cancel(clientKey)
+	if !ok {
+		p.debugf("ignore cancellation of unknown request %s", clientKey)
+		return nil, false
+	}
+	p.debugf("cancelled request %s (upstream id %s)", clientKey, bridgeID)
+	out, err := withCancelledRequestID(msg, bridgeID)
+	if err != nil {
+		p.debugf("rewrite cancellation: %v", err)
+		return nil, false
+	}
+	return out, true
+}
+
+// pendingRequest は calls に登録済みで、サーバーへの送信を待っているリクエストです。
+type pendingRequest struct {
+	msg []byte
+	c   *call
+	// reqCtx はクライアントのキャンセルとタイムアウトで終了する、このリクエストの ctx
+	reqCtx context.Context
+	// outbound は id をブリッジの id に置き換えた、サーバーへ送るメッセージ
+	outbound []byte
+}
+
+// acceptRequest はリクエスト msg を calls に登録してブリッジの id を割り当てます。タイムアウトは登録した時点から数えます。
+// 登録したリクエストは complete で送るか、calls.finish で取り除いてください。
+func (p *Proxy) acceptRequest(ctx context.Context, msg []byte) (*pendingRequest, error) {
+	c, reqCtx, outbound, err := p.calls.start(ctx, msg, p.requestTimeout(msg))
+	if err != nil {
+		return nil, err
+	}
+	return &pendingRequest{msg: msg, c: c, reqCtx: reqCtx, outbound: outbound}, nil
+}
+
+// complete は登録済みのリクエスト r をサーバーへ送り、id をクライアントの id に戻したレスポンスを返します。
+// レスポンスは POST の応答として返っても、listen のストリームで届いても構いません。
+// 送る前に r.reqCtx が終了していた（空きを待つ間にキャンセルまたはタイムアウトした）場合はサーバーへ送りません。
+func (p *Proxy) complete(ctx context.Context, tr transport, r *pendingRequest, ch chan<- []byte) (resp []byte, ok bool) {
+	c, reqCtx := r.c, r.reqCtx
+	defer p.calls.finish(c)
+	if reqCtx.Err() != nil {
+		return p.abandon(reqCtx, c)
+	}
+
+	err := p.exchange(reqCtx, tr, r.outbound, []*call{c}, ch)
+	resp, ok = p.result(reqCtx, c, err)
+	if timedOut(reqCtx, c) {
+		p.cancelUpstream(ctx, tr, c, ch)
+	}
+	if ok && requestMethod(r.msg) == "initialize" {
+		p.observeInitialize(resp)
+	}
+	return resp, ok
+}
+
+// exchange は outbound をサーバーへ送り、その応答として返ってきたレスポンスのうち calls 宛てのものを各リクエストに渡します。
+// POST には相関 ID を付け、失敗した場合は相関 ID を添えたエラーを返します。
+// 応答の id が null のエラーは、calls のうち未完了のものが 1 件だけならそのリクエストに渡し、それ以外は捨てます。
+// calls 宛てでないメッセージは dispatch に渡します。
+func (p *Proxy) exchange(ctx context.Context, tr transport, outbound []byte, calls []*call, ch chan<- []byte) error {
+	id := newCorrelationID()
+	ctx = withCorrelationID(ctx, id)
+
+	replies := make(chan []byte)
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- tr.send(ctx, outbound, replies)
+		close(replies)
+	}()
+	var malformed []byte
+	for reply := range replies {
+		if !json.Valid(reply) {
+			p.debugf("malformed JSON from server: %s", excerpt(reply))
+			malformed = reply
 			continue
 		}
-		req.Header.Set("Accept", "text/event-stream")
-		p.addAuthHeader(req)
-
-		resp, err := p.client.Do(req)
-		if err != nil {
-			if p.cfg.Debug {
-				fmt.Fprintf(os.Stderr, "[proxy] SSE request error: %v\n", err)
+		for _, msg := range splitBatch(reply) {
+			if classify(msg) == kindResponse && claimResponse(calls, msg) {
+				continue
 			}
-			time.Sleep(2 * time.Second)
-			continue
+			p.dispatch(ctx, msg, ch)
 		}
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			if p.cfg.Debug {
-				fmt.Fprintf(os.Stderr, "[proxy] SSE status=%d\n", resp.StatusCode)
-			}
-			time.Sleep(2 * time.Second)
-			continue
+	err := <-sendErr
+	if err == nil && malformed != nil && pending(calls) {
+		// 解釈できない応答しか返らなかったリクエストは、いつまでも待たせずにエラーにする
+		err = &malformedError{body: malformed}
+	}
+	if err != nil {
+		return &correlatedError{id: id, err: err}
+	}
+	return nil
+}
+
+// result は c のレスポンスを待ち、id をクライアントの id に戻して返します。sendErr が nil でなければエラーレスポンスを返します。
+// reqCtx がタイムアウトした場合はタイムアウトのエラーレスポンスを返し、それ以外の理由でキャンセルされた場合はレスポンスが届いていても ok に false を返します。
+func (p *Proxy) result(reqCtx context.Context, c *call, sendErr error) (resp []byte, ok bool) {
+	if sendErr != nil {
+		if reqCtx.Err() != nil || timedOut(reqCtx, c) {
+			return p.abandon(reqCtx, c)
 		}
+		p.debugf("send: %v", sendErr)
+		return failureResponse(c.clientID, sendErr), true
+	}
 
-		p.readSSEStream(ctx, resp.Body, ch)
-		resp.Body.Close()
+	select {
+	case <-c.done:
+	case <-reqCtx.Done():
+	}
+	if reqCtx.Err() != nil && !timedOut(reqCtx, c) {
+		// キャンセルされたリクエストには、レスポンスが届いていてもクライアントへ返さない
+		return nil, false
 	}
+	if !c.resolved() {
+		return p.abandon(reqCtx, c)
+	}
+	if c.cancelled {
+		return nil, false
+	}
+	resp, err := c.response()
+	if err != nil {
+		p.debugf("restore request id: %v", err)
+		return errorResponse(c.clientID, codeInternalError, fmt.Sprintf("invalid response from server: %v", err)), true
+	}
+	return resp, true
 }
 
-// readSSEStream は SSE ストリームをパースし、JSON-RPC レスポンスと思われる data のみ ch に送ります。
-// サーバーが送る endpoint 通知（例: {"url":"/mcp"}）は stdout に転送せず、プロトコル上は POST のレスポンスのみを stdout に返す想定です。
-func (p *Proxy) readSSEStream(ctx context.Context, r io.Reader, ch chan<- []byte) {
-	scanner := bufio.NewScanner(r)
-	scanner.Buffer(nil, 1024*1024)
+// abandon はレスポンスを得る前に reqCtx が終了したリクエスト c の扱いを決めます。
+// タイムアウトの場合はタイムアウトのエラーレスポンスを返し、クライアントのキャンセルや終了処理による打ち切りでは ok に false を返します。
+func (p *Proxy) abandon(reqCtx context.Context, c *call) (resp []byte, ok bool) {
+	if !timedOut(reqCtx, c) {
+		return nil, false
+	}
+	p.debugf("%s (upstream id %s) timed out after %s", c.method, c.bridgeID, c.timeout)
+	return failureResponse(c.clientID, &timeoutError{method: c.method, after: c.timeout}), true
+}
 
-	var currentData []byte
-	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
-			return
+// timedOut は reqCtx が c のタイムアウトで終了したかどうかを返します。
+// 期限を過ぎていればタイマーによる reqCtx の終了がまだ反映されていなくてもタイムアウトとみなします
+// （バッチの POST はリクエストごとの ctx と別の ctx で打ち切るため、どちらが先に終了するかは決まらない）。
+func timedOut(reqCtx context.Context, c *call) bool {
+	if c.timeout <= 0 {
+		return false
+	}
+	if err := reqCtx.Err(); err != nil {
+		return errors.Is(err, context.DeadlineExceeded)
+	}
+	deadline, ok := reqCtx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// cancelNotifyTimeout はタイムアウトしたリクエストのキャンセル通知を送る際に待つ時間です。
+const cancelNotifyTimeout = 5 * time.Second
+
+// cancelUpstream はタイムアウトした c のキャンセルを notifications/cancelled でサーバーに伝え、サーバーに処理を止めさせます。
+// レスポンスを先にクライアントへ返せるよう、通知は別の goroutine で送ります。届かなくても何もしません。
+func (p *Proxy) cancelUpstream(ctx context.Context, tr transport, c *call, ch chan<- []byte) {
+	msg, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "notifications/cancelled",
+		"params": map[string]any{
+			"requestId": c.bridgeID,
+			"reason":    fmt.Sprintf("Request timed out after %s", c.timeout),
+		},
+	})
+	if err != nil {
+		return
+	}
+	p.cancels.Add(1)
+	go func() {
+		defer p.cancels.Done()
+		ctx, cancel := context.WithTimeout(ctx, cancelNotifyTimeout)
+		defer cancel()
+		p.sendOneWay(ctx, tr, msg, ch)
+	}()
+}
+
+// requestTimeout は設定の Timeouts から、リクエスト msg のレスポンスを待つ時間を返します。
+func (p *Proxy) requestTimeout(msg []byte) time.Duration {
+	return p.cfg.Timeouts.For(requestMethod(msg), toolName(msg))
+}
+
+// pendingBatch は要素を calls に登録済みで、サーバーへの送信を待っているバッチです。各スライスは要素と同じ順に並びます。
+type pendingBatch struct {
+	// resps は要素ごとのレスポンス。送る前にエラーと決まった要素にはそのエラーを入れておく
+	resps [][]byte
+	// reqs は要素ごとの登録済みのリクエスト。リクエストでない要素は nil
+	reqs []*pendingRequest
+	// oneWay は要素ごとのサーバーへ送る通知やレスポンス。送らない要素は nil
+	oneWay [][]byte
+}
+
+// acceptBatch はバッチ msg を要素に分け、リクエストを calls に登録し、通知やレスポンスを prepareOneWay で処理します。
+// バッチとして解釈できない（空の配列を含む）場合はエラーを返します。
+func (p *Proxy) acceptBatch(ctx context.Context, msg []byte) (*pendingBatch, error) {
+	var elems []json.RawMessage
+	if err := json.Unmarshal(msg, &elems); err != nil {
+		return nil, err
+	}
+	if len(elems) == 0 {
+		return nil, errors.New("empty batch")
+	}
+
+	b := &pendingBatch{
+		resps:  make([][]byte, len(elems)),
+		reqs:   make([]*pendingRequest, len(elems)),
+		oneWay: make([][]byte, len(elems)),
+	}
+	for i, el := range elems {
+		switch classify(el) {
+		case kindRequest:
+			r, err := p.acceptRequest(ctx, el)
+			if err != nil {
+				b.resps[i] = invalidResponse(el)
+				continue
+			}
+			b.reqs[i] = r
+		case kindNotification, kindResponse:
+			if out, ok := p.prepareOneWay(el); ok {
+				b.oneWay[i] = out
+			}
 		default:
+			// バッチの入れ子や JSON-RPC として解釈できない要素
+			b.resps[i] = invalidResponse(el)
 		}
+	}
+	return b, nil
+}
 
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			if len(currentData) > 0 {
-				if isJSONRPCResponse(currentData) {
-					cp := normalizeResponseID(currentData)
-					if len(cp) > 0 {
-						select {
-						case ch <- cp:
-						case <-ctx.Done():
-							return
-						}
-					}
-				}
-				currentData = nil
+// completeBatch は登録済みのバッチ b をサーバーへ送り、レスポンスをリクエストの順に 1 つの配列にまとめて ch に送ります。
+// サーバーが合意したプロトコルバージョンがバッチに対応していればバッチのまま転送し、そうでなければ要素ごとに送ります。
+// どちらの場合も要素ごとに id を割り当て直し、エラーも要素ごとに返します。レスポンスが 1 件もない（通知だけの）場合は何も送りません。
+func (p *Proxy) completeBatch(ctx context.Context, tr transport, b *pendingBatch, ch chan<- []byte) {
+	var resps [][]byte
+	if p.supportsBatch() {
+		resps = p.forwardBatch(ctx, tr, b, ch)
+	} else {
+		resps = p.sendEach(ctx, tr, b, ch)
+	}
+	out := joinBatch(resps)
+	if out == nil {
+		return
+	}
+	select {
+	case ch <- out:
+	case <-ctx.Done():
+	}
+}
+
+// forwardBatch はバッチ b を 1 回の POST でサーバーへ送ります。
+// 戻り値は要素ごとのレスポンスで、レスポンスを返さない要素は nil です。
+func (p *Proxy) forwardBatch(ctx context.Context, tr transport, b *pendingBatch, ch chan<- []byte) [][]byte {
+	resps := b.resps
+	var (
+		outbound [][]byte
+		calls    []*call
+		reqCtxs  []context.Context
+		index    []int
+		// longest は要素のタイムアウトのうち最長のもの。POST 自体はこれで打ち切る。
+		longest time.Duration
+	)
+	for i, r := range b.reqs {
+		switch {
+		case r != nil:
+			defer p.calls.finish(r.c)
+			if r.reqCtx.Err() != nil {
+				// 空きを待つ間にキャンセルまたはタイムアウトしたリクエストは送らない
+				resps[i], _ = p.abandon(r.reqCtx, r.c)
+				continue
 			}
-			continue
+			outbound = append(outbound, r.outbound)
+			calls = append(calls, r.c)
+			reqCtxs = append(reqCtxs, r.reqCtx)
+			index = append(index, i)
+			longest = max(longest, r.c.timeout)
+		case b.oneWay[i] != nil:
+			outbound = append(outbound, b.oneWay[i])
 		}
+	}
+	if len(outbound) == 0 {
+		return resps
+	}
 
-		if bytes.HasPrefix(line, []byte("data:")) {
-			data := bytes.TrimSpace(line[5:])
-			if len(data) > 0 {
-				currentData = append(currentData, data...)
-			}
+	exCtx := ctx
+	if longest > 0 {
+		var cancel context.CancelFunc
+		exCtx, cancel = context.WithTimeout(ctx, longest)
+		defer cancel()
+	}
+	err := p.exchange(exCtx, tr, joinBatch(outbound), calls, ch)
+	for j, c := range calls {
+		if resp, ok := p.result(reqCtxs[j], c, err); ok {
+			resps[index[j]] = resp
+		}
+		if timedOut(reqCtxs[j], c) {
+			p.cancelUpstream(ctx, tr, c, ch)
 		}
 	}
+	return resps
 }
 
-// normalizeResponseID は JSON-RPC レスポンスの id が欠損または null の場合に 0 を設定します。
-// Claude Desktop は id に null を許容しないため、転送前に必ず string/number にします。
-func normalizeResponseID(body []byte) []byte {
-	var m map[string]any
-	if err := json.Unmarshal(body, &m); err != nil {
-		return body
+// sendEach はバッチ b の要素を 1 件ずつサーバーへ送ります（バッチに対応していないサーバー向け）。
+// 戻り値は要素ごとのレスポンスで、レスポンスを返さない要素は nil です。
+func (p *Proxy) sendEach(ctx context.Context, tr transport, b *pendingBatch, ch chan<- []byte) [][]byte {
+	resps := b.resps
+	var wg sync.WaitGroup
+	for i, r := range b.reqs {
+		switch {
+		case r != nil:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if resp, ok := p.complete(ctx, tr, r, ch); ok {
+					resps[i] = resp
+				}
+			}()
+		case b.oneWay[i] != nil:
+			p.sendOneWay(ctx, tr, b.oneWay[i], ch)
+		}
 	}
-	if m == nil {
-		return body
+	wg.Wait()
+	return resps
+}
+
+// prepareOneWay はレスポンスを返さないメッセージを送信前に処理します。
+// notifications/cancelled は該当リクエストを止め、requestId をブリッジの id に置き換えます。送る必要がない場合は ok に false を返します。
+func (p *Proxy) prepareOneWay(msg []byte) ([]byte, bool) {
+	if id, ok := cancelledRequestID(msg); ok {
+		return p.translateCancel(id, msg)
 	}
-	_, hasResult := m["result"]
-	_, hasError := m["error"]
-	if !hasResult && !hasError {
-		return body
+	return msg, true
+}
+
+// observeInitialize は initialize のレスポンスからサーバーと合意したプロトコルバージョンを記録します。
+func (p *Proxy) observeInitialize(resp []byte) {
+	version := protocolVersion(resp)
+	if version == "" {
+		return
 	}
-	id, ok := m["id"]
-	if ok && id != nil {
-		switch id.(type) {
-		case string, float64:
-			return body
+	p.mu.Lock()
+	p.protocolVersion = version
+	p.mu.Unlock()
+	p.debugf("negotiated protocol version %s", version)
+}
+
+// supportsBatch はサーバーと合意したプロトコルバージョンが JSON-RPC バッチに対応しているかどうかを返します。
+// MCP でバッチを扱えるのは 2025-03-26 だけです（2025-06-18 で削除された）。
+func (p *Proxy) supportsBatch() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.protocolVersion == batchProtocolVersion
+}
+
+// dispatch はサーバーから届いた、特定の POST に紐付かないメッセージを処理します。
+// レスポンスは id の一致する実行中のリクエストに渡し、該当が無いもの（キャンセル済みなど）は捨てます。
+// サーバーからクライアントへのリクエスト（sampling/createMessage、roots/list、elicitation/create など）は stdout へ転送します。
+// クライアントの応答は stdin からレスポンスとして届き、sendOneWay でサーバーへ POST されます。
+// 通知（notifications/progress、notifications/message、notifications/tools/list_changed など）は設定の Notifications で許可されたものだけを転送します。
+func (p *Proxy) dispatch(ctx context.Context, msg []byte, ch chan<- []byte) {
+	for _, m := range splitBatch(msg) {
+		switch classify(m) {
+		case kindResponse:
+			if !p.calls.resolve(m) {
+				p.debugf("drop response with no matching request: %s", m)
+			}
+		case kindRequest:
+			p.debugf("server request: %s", requestMethod(m))
+			select {
+			case ch <- m:
+			case <-ctx.Done():
+				return
+			}
+		case kindNotification:
+			if method := requestMethod(m); !p.cfg.Notifications.Allows(method) {
+				p.debugf("filtered server notification: %s", method)
+				continue
+			}
+			select {
+			case ch <- m:
+			case <-ctx.Done():
+				return
+			}
+		default:
+			// endpoint 通知など JSON-RPC でないものは転送しない
 		}
 	}
-	m["id"] = 0
-	out, err := json.Marshal(m)
-	if err != nil {
-		return body
+}
+
+// sendOneWay は通知やレスポンスなど、クライアントへの返信を伴わないメッセージをサーバーへ送ります。
+// 送信に失敗してもエラーレスポンスは返さず、サーバーが返した id が null のレスポンスも stdout には転送しません。
+// このメッセージに対するものではないメッセージ（他のリクエストへのレスポンスなど）は dispatch に渡します。
+// 送信は Timeouts の method（レスポンスの場合は Default）の時間で打ち切り、応答しないサーバーが後続の通知を止め続けないようにします。
+func (p *Proxy) sendOneWay(ctx context.Context, tr transport, msg []byte, ch chan<- []byte) {
+	sendCtx, cancel := context.WithCancel(ctx)
+	if timeout := p.cfg.Timeouts.For(requestMethod(msg), ""); timeout > 0 {
+		sendCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	if err := p.exchange(sendCtx, tr, msg, nil, ch); err != nil && ctx.Err() == nil {
+		p.debugf("send one-way message: %v", err)
 	}
-	return out
 }
 
-// isJSONRPCResponse は data が JSON-RPC レスポンス（result または error を持つ）かどうかを判定します。
-// endpoint 通知 {"url":"/mcp"} は転送しないため false を返します。
-func isJSONRPCResponse(data []byte) bool {
-	var m map[string]json.RawMessage
-	if err := json.Unmarshal(data, &m); err != nil {
-		return false
+// rejectInvalid は JSON-RPC として解釈できない入力にエラーレスポンスを返します（JSON-RPC 2.0 5.1）。
+// id を特定できない場合は仕様どおり null にします。
+func (p *Proxy) rejectInvalid(ctx context.Context, ch chan<- []byte, msg []byte) {
+	p.debugf("invalid message from stdin: %s", msg)
+	send(ctx, ch, invalidResponse(msg))
+}
+
+// rejectTooLarge は上限を超えたメッセージにエラーレスポンスを返します。セッションはそのまま続けます。
+// head は読み捨てたメッセージの先頭部分で、id が見つかればその id 宛てに、見つからなければ id を null にして返します。
+func (p *Proxy) rejectTooLarge(ctx context.Context, ch chan<- []byte, head []byte) {
+	limit := p.cfg.MaxMessageBytesOrDefault()
+	id, _ := leadingID(head)
+	p.debugf("message exceeds %d bytes (id %s), dropped", limit, id)
+	send(ctx, ch, errorResponse(id, codeInvalidRequest, fmt.Sprintf("Message too large: exceeds the limit of %d bytes", limit)))
+}
+
+// writeStdout は ch のメッセージを 1 件ずつ改行区切りで stdout に書き込みます。
+// 書き込みに失敗した後も ch は最後まで読み捨て、送信側が待ち続けないようにします。
+func (p *Proxy) writeStdout(ch <-chan []byte) {
+	var failed bool
+	for b := range ch {
+		if failed {
+			continue
+		}
+		if len(b) == 0 || b[len(b)-1] != '\n' {
+			b = append(b[:len(b):len(b)], '\n')
+		}
+		if _, err := p.stdout.Write(b); err != nil {
+			p.debugf("stdout write error: %v", err)
+			failed = true
+		}
 	}
-	_, hasResult := m["result"]
-	_, hasError := m["error"]
-	return hasResult || hasError
 }
 
-func (p *Proxy) addAuthHeader(req *http.Request) {
-	// 開発用: プロファイルやトークンがあれば付与。今回はダミーまたは未使用。
-	if p.cfg.Profile != "" && p.cfg.Debug {
-		req.Header.Set("X-Profile", p.cfg.Profile)
+// readSSEStream は SSE ストリームを sse.Decoder で読み、data が空でない各イベントを fn に渡します。fn が false を返すと読み込みを終了します。
+// st が nil でなければ、最後のイベント ID と retry を st に記録します（再接続時の Last-Event-ID と待ち時間に使う）。
+// 戻り値は fn に渡したイベントの数です。
+func readSSEStream(ctx context.Context, r io.Reader, st *sseState, fn func(ev sse.Event) bool) int {
+	dec := sse.NewDecoder(r)
+	if st != nil {
+		dec.SetLastEventID(st.lastEventID)
 	}
+	n := 0
+	for {
+		ev, err := dec.Next()
+		if st != nil {
+			st.lastEventID = dec.LastEventID()
+			if retry, ok := dec.Retry(); ok {
+				st.retry = retry
+			}
+		}
+		if err != nil || ctx.Err() != nil {
+			return n
+		}
+		if ev.Data == "" {
+			continue
+		}
+		n++
+		if !fn(ev) {
+			return n
+		}
+	}
+}
+
+// sseState は再接続をまたいで引き継ぐ SSE ストリームの状態です。
+type sseState struct {
+	// lastEventID は最後に受け取ったイベント ID。再接続時に Last-Event-ID として送る。
+	lastEventID string
+	// retry はサーバーが retry フィールドで指定した再接続の待ち時間。0 の場合は既定値を使う。
+	retry time.Duration
 }
 
-// extractRequestID は JSON-RPC リクエストの id を返します。null/欠損/パース失敗時は 0 を返します。
-// Claude Desktop は id に null を許容しないため、必ず string または number にします。
-func extractRequestID(line []byte) any {
-	var m struct {
-		ID any `json:"id"`
+// do は newReq で組み立てたリクエストに認証ヘッダーを付けて送信します。
+// サーバーが 401 を返した場合はトークンをリフレッシュし、リクエストを組み立て直して 1 回だけ再送します。
+func (p *Proxy) do(newReq func() (*http.Request, error)) (*http.Response, error) {
+	req, err := newReq()
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
 	}
-	if err := json.Unmarshal(line, &m); err != nil {
-		return 0
+	tok := p.addAuthHeader(req)
+
+	resp, err := p.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || p.tokens == nil {
+		return resp, err
 	}
-	if m.ID == nil {
-		return 0
+
+	refreshed, rerr := p.tokens.Refresh(req.Context(), tok)
+	if rerr != nil {
+		p.debugf("token refresh after 401 failed: %v", rerr)
+		return resp, nil
 	}
-	switch m.ID.(type) {
-	case string, float64: // JSON number → float64
-		return m.ID
-	default:
-		return 0
+	resp.Body.Close()
+
+	retry, err := newReq()
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
 	}
+	retry.Header.Set("Authorization", "Bearer "+refreshed.AccessToken)
+	return p.client.Do(retry)
 }
 
-func (p *Proxy) sendError(ch chan<- []byte, requestID any, msg string, err error) {
-	errResp := map[string]any{
-		"jsonrpc": "2.0",
-		"error": map[string]any{
-			"code":    -32603,
-			"message": fmt.Sprintf("%s: %v", msg, err),
-		},
-		"id": requestID,
+// addAuthHeader はプロファイルのアクセストークンを Authorization: Bearer として付与し、使ったトークンを返します。
+// 期限切れが近い場合は付与前にリフレッシュします。トークンが保存されていない場合は何も付与せず nil を返します（認証なしのローカルサーバー向け）。
+func (p *Proxy) addAuthHeader(req *http.Request) *auth.Token {
+	if p.tokens == nil {
+		return nil
+	}
+	tok, err := p.tokens.Token(req.Context())
+	if err != nil {
+		if !errors.Is(err, auth.ErrNoToken) {
+			p.debugf("load token: %v", err)
+		}
+		return nil
 	}
-	body, _ := json.Marshal(errResp)
+	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+	return tok
+}
+
+// send は body を ch に送ります。ch が一杯の場合は空くまで待ち、ctx が終了した場合だけ諦めます。
+func send(ctx context.Context, ch chan<- []byte, body []byte) {
 	select {
 	case ch <- body:
-	default:
-		if p.cfg.Debug {
-			fmt.Fprintf(os.Stderr, "[proxy] error (channel full): %s: %v\n", msg, err)
-		}
+	case <-ctx.Done():
+	}
+}
+
+// debugf は Debug が有効な場合のみ stderr にログを出力します。
+func (p *Proxy) debugf(format string, args ...any) {
+	if p.cfg.Debug {
+		fmt.Fprintf(os.Stderr, "[proxy] "+format+"\n", args...)
 	}
 }
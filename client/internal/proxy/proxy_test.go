@@ -0,0 +1,1036 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/auth"
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+)
+
+// memStore はテスト用のメモリ上の auth.TokenStore です。
+type memStore struct {
+	mu  sync.Mutex
+	tok *auth.Token
+}
+
+func (s *memStore) Load(string) (*auth.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok == nil {
+		return nil, auth.ErrNoToken
+	}
+	cp := *s.tok
+	return &cp, nil
+}
+
+func (s *memStore) Save(_ string, tok *auth.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *tok
+	s.tok = &cp
+	return nil
+}
+
+func (s *memStore) Delete(string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tok = nil
+	return nil
+}
+
+// newTokenServer は discovery と refresh_token グラントだけを持つ OIDC プロバイダを起動します。
+func newTokenServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": srv.URL, "token_endpoint": srv.URL + "/token"})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" {
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "fresh", "expires_in": 3600})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxy_do_refreshesOn401(t *testing.T) {
+	issuer := newTokenServer(t)
+
+	tests := []struct {
+		name       string
+		stored     *auth.Token
+		wantStatus int
+		wantAuth   []string
+	}{
+		{
+			name:       "retries once with refreshed token",
+			stored:     &auth.Token{AccessToken: "revoked", RefreshToken: "r", Expiry: time.Now().Add(time.Hour)},
+			wantStatus: http.StatusOK,
+			wantAuth:   []string{"Bearer revoked", "Bearer fresh"},
+		},
+		{
+			name:       "no refresh token returns 401",
+			stored:     &auth.Token{AccessToken: "revoked", Expiry: time.Now().Add(time.Hour)},
+			wantStatus: http.StatusUnauthorized,
+			wantAuth:   []string{"Bearer revoked"},
+		},
+		{
+			name:       "expired token refreshed before sending",
+			stored:     &auth.Token{AccessToken: "expired", RefreshToken: "r", Expiry: time.Now().Add(-time.Minute)},
+			wantStatus: http.StatusOK,
+			wantAuth:   []string{"Bearer fresh"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var gotAuth []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				gotAuth = append(gotAuth, r.Header.Get("Authorization"))
+				mu.Unlock()
+				if r.Header.Get("Authorization") != "Bearer fresh" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			p := &Proxy{
+				cfg:    &config.Config{URL: server.URL + "/sse"},
+				client: server.Client(),
+				tokens: &auth.Source{
+					Client:  &auth.Client{Issuer: issuer.URL, ClientID: "c"},
+					Store:   &memStore{tok: tt.stored},
+					Profile: "default",
+				},
+			}
+			resp, err := p.do(func() (*http.Request, error) {
+				return http.NewRequest(http.MethodPost, server.URL+"/mcp", nil)
+			})
+			if err != nil {
+				t.Fatalf("do() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if len(gotAuth) != len(tt.wantAuth) {
+				t.Fatalf("requests = %v, want %v", gotAuth, tt.wantAuth)
+			}
+			for i := range gotAuth {
+				if gotAuth[i] != tt.wantAuth[i] {
+					t.Errorf("request[%d] Authorization = %q, want %q", i, gotAuth[i], tt.wantAuth[i])
+				}
+			}
+		})
+	}
+}
+
+// fakeTransport はテスト用の transport です。method が "slow" のリクエストは release が閉じられるまで応答しません。
+type fakeTransport struct {
+	release chan struct{}
+
+	mu       sync.Mutex
+	inflight int
+	maxSeen  int
+	sent     []string
+}
+
+func (f *fakeTransport) send(ctx context.Context, msg []byte, ch chan<- []byte) error {
+	var m struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	_ = json.Unmarshal(msg, &m)
+
+	f.mu.Lock()
+	f.sent = append(f.sent, m.Method)
+	f.inflight++
+	if f.inflight > f.maxSeen {
+		f.maxSeen = f.inflight
+	}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inflight--
+		f.mu.Unlock()
+	}()
+
+	if m.Method == "slow" {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if m.ID != nil {
+		ch <- []byte(fmt.Sprintf(`{"jsonrpc":"2.0","result":{},"id":%s}`, m.ID))
+	}
+	return nil
+}
+
+func (f *fakeTransport) listen(context.Context, chan<- []byte) {}
+
+func TestProxy_runStdinToPost_concurrent(t *testing.T) {
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"slow","id":1}`,
+		`{"jsonrpc":"2.0","method":"notifications/a"}`,
+		`{"jsonrpc":"2.0","method":"slow","id":2}`,
+		`{"jsonrpc":"2.0","method":"notifications/b"}`,
+		`{"jsonrpc":"2.0","method":"ping","id":3}`,
+		`{"jsonrpc":"2.0","method":"notifications/c"}`,
+	}, "\n") + "\n"
+
+	tr := &fakeTransport{release: make(chan struct{})}
+	p := &Proxy{
+		cfg:   &config.Config{MaxConcurrency: 3},
+		stdin: strings.NewReader(input),
+		calls: newCallTable(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch := make(chan []byte, 8)
+	done := make(chan struct{})
+	go func() {
+		p.runStdinToPost(ctx, newShutdown(), tr, ch)
+		close(done)
+	}()
+
+	// slow な 2 件が応答する前に ping の応答が届く
+	select {
+	case got := <-ch:
+		if !strings.Contains(string(got), `"id":3`) {
+			t.Fatalf("first response = %s, want ping (id 3)", got)
+		}
+	case <-ctx.Done():
+		t.Fatal("ping was blocked behind slow requests")
+	}
+	close(tr.release)
+	<-done
+
+	if tr.maxSeen > 3 {
+		t.Errorf("max in-flight = %d, want <= 3", tr.maxSeen)
+	}
+	var notifications []string
+	for _, m := range tr.sent {
+		if strings.HasPrefix(m, "notifications/") {
+			notifications = append(notifications, m)
+		}
+	}
+	if strings.Join(notifications, ",") != "notifications/a,notifications/b,notifications/c" {
+		t.Errorf("notification order = %v", notifications)
+	}
+	if len(ch) != 2 {
+		t.Errorf("remaining responses = %d, want 2", len(ch))
+	}
+}
+
+func TestProxy_runStdinToPost_concurrencyLimit(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 5; i++ {
+		lines = append(lines, fmt.Sprintf(`{"jsonrpc":"2.0","method":"slow","id":%d}`, i))
+	}
+	tr := &fakeTransport{release: make(chan struct{})}
+	p := &Proxy{
+		cfg:   &config.Config{MaxConcurrency: 2},
+		stdin: strings.NewReader(strings.Join(lines, "\n")),
+		calls: newCallTable(),
+	}
+	ch := make(chan []byte, 8)
+	done := make(chan struct{})
+	go func() {
+		p.runStdinToPost(context.Background(), newShutdown(), tr, ch)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	tr.mu.Lock()
+	sent := len(tr.sent)
+	tr.mu.Unlock()
+	if sent != 2 {
+		t.Errorf("requests sent while blocked = %d, want 2", sent)
+	}
+	close(tr.release)
+	<-done
+	if len(ch) != 5 {
+		t.Errorf("responses = %d, want 5", len(ch))
+	}
+}
+
+func TestProxy_runStdinToPost_readsWhileLimited(t *testing.T) {
+	// 同時実行数の上限に達していても、後続の通知や不正な入力は待たされずに処理される
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"slow","id":1}`,
+		`{"jsonrpc":"2.0","method":"slow","id":2}`,
+		`{"jsonrpc":"2.0","method":"notifications/a"}`,
+		`{"jsonrpc":`,
+	}, "\n") + "\n"
+	tr := &fakeTransport{release: make(chan struct{})}
+	p := &Proxy{
+		cfg:   &config.Config{MaxConcurrency: 1},
+		stdin: strings.NewReader(input),
+		calls: newCallTable(),
+	}
+	ch := make(chan []byte, 8)
+	done := make(chan struct{})
+	go func() {
+		p.runStdinToPost(context.Background(), newShutdown(), tr, ch)
+		close(done)
+	}()
+
+	select {
+	case got := <-ch:
+		if !strings.Contains(string(got), "Parse error") {
+			t.Fatalf("first response = %s, want parse error", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stdin reading was blocked by the concurrency limit")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tr.mu.Lock()
+		sent := slices.Sorted(slices.Values(tr.sent))
+		tr.mu.Unlock()
+		if strings.Join(sent, ",") == "notifications/a,slow" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sent = %v, want one slow and notifications/a while the second request waits", sent)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(tr.release)
+	<-done
+	if len(ch) != 2 {
+		t.Errorf("remaining responses = %d, want 2", len(ch))
+	}
+}
+
+func TestProxy_runStdinToPost_notifications(t *testing.T) {
+	// このリポジトリの Kotlin サーバーと同様、通知にも id なしのエラーを返すサーバー
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case req.Method == "notifications/fail":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case req.ID == nil:
+			fmt.Fprint(w, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"}}`)
+		default:
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","result":{},"id":%s}`, req.ID)
+		}
+	}))
+	defer srv.Close()
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","method":"notifications/fail"}`,
+		`{"jsonrpc":"2.0","method":"tools/list","id":9}`,
+		`{"jsonrpc":`,
+		`{"jsonrpc":"2.0","id":"x"}`,
+	}, "\n")
+	p := &Proxy{
+		cfg:    &config.Config{URL: srv.URL},
+		client: srv.Client(),
+		stdin:  strings.NewReader(input),
+		calls:  newCallTable(),
+	}
+	tr := newStreamableTransport(p)
+	ch := make(chan []byte, 8)
+	p.runStdinToPost(context.Background(), newShutdown(), tr, ch)
+	close(ch)
+
+	var got []string
+	for b := range ch {
+		got = append(got, string(b))
+	}
+	want := map[string]bool{
+		`{"jsonrpc":"2.0","result":{},"id":9}`:                                           true,
+		`{"error":{"code":-32700,"message":"Parse error"},"id":null,"jsonrpc":"2.0"}`:    true,
+		`{"error":{"code":-32600,"message":"Invalid Request"},"id":"x","jsonrpc":"2.0"}`: true,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("stdout messages = %v, want %d messages", got, len(want))
+	}
+	for _, g := range got {
+		if !want[g] {
+			t.Errorf("unexpected stdout message %s", g)
+		}
+	}
+}
+
+// echoTransport は params.n を result に入れて返します。method が "bad" のリクエストには
+// id が null のエラーを返します（サーバーがリクエストの id を読み取れなかった場合の再現）。
+type echoTransport struct{}
+
+func (echoTransport) send(_ context.Context, msg []byte, ch chan<- []byte) error {
+	var m struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Params struct {
+			N int `json:"n"`
+		} `json:"params"`
+	}
+	_ = json.Unmarshal(msg, &m)
+	if m.ID == nil {
+		return nil
+	}
+	if m.Method == "bad" {
+		ch <- []byte(`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`)
+		return nil
+	}
+	ch <- []byte(fmt.Sprintf(`{"jsonrpc":"2.0","result":{"n":%d},"id":%s}`, m.Params.N, m.ID))
+	return nil
+}
+
+func (echoTransport) listen(context.Context, chan<- []byte) {}
+
+func TestProxy_requestIDs(t *testing.T) {
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"m","params":{"n":1},"id":1}`,
+		`{"jsonrpc":"2.0","method":"m","params":{"n":2},"id":1}`,
+		`{"jsonrpc":"2.0","method":"m","params":{"n":3},"id":null}`,
+		`{"jsonrpc":"2.0","method":"m","params":{"n":4},"id":"1"}`,
+		`{"jsonrpc":"2.0","method":"m","params":{"n":5},"id":{"k":[1]}}`,
+		`{"jsonrpc":"2.0","method":"bad","id":"b"}`,
+	}, "\n")
+	p := &Proxy{
+		cfg:   &config.Config{},
+		stdin: strings.NewReader(input),
+		calls: newCallTable(),
+	}
+	ch := make(chan []byte, 8)
+	p.runStdinToPost(context.Background(), newShutdown(), echoTransport{}, ch)
+	close(ch)
+
+	got := map[string]bool{}
+	for b := range ch {
+		got[string(b)] = true
+	}
+	want := []string{
+		`{"jsonrpc":"2.0","result":{"n":1},"id":1}`,
+		`{"jsonrpc":"2.0","result":{"n":2},"id":1}`,
+		`{"jsonrpc":"2.0","result":{"n":3},"id":null}`,
+		`{"jsonrpc":"2.0","result":{"n":4},"id":"1"}`,
+		`{"jsonrpc":"2.0","result":{"n":5},"id":{"k":[1]}}`,
+		`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":"b"}`,
+	}
+	if len(got) != len(want) {
+		t.Errorf("stdout = %v, want %d distinct responses", got, len(want))
+	}
+	for _, w := range want {
+		if !got[w] {
+			t.Errorf("missing response %s", w)
+		}
+	}
+}
+
+// batchTransport は送られたメッセージを記録し、リクエストごとに params.n を result に入れて返します。
+// バッチが送られた場合はレスポンスも 1 つの配列で返します。
+type batchTransport struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (b *batchTransport) send(_ context.Context, msg []byte, ch chan<- []byte) error {
+	b.mu.Lock()
+	b.sent = append(b.sent, string(msg))
+	b.mu.Unlock()
+
+	var resps [][]byte
+	for _, el := range splitBatch(msg) {
+		var m struct {
+			ID     json.RawMessage `json:"id"`
+			Params struct {
+				N int `json:"n"`
+			} `json:"params"`
+		}
+		_ = json.Unmarshal(el, &m)
+		if m.ID != nil {
+			resps = append(resps, []byte(fmt.Sprintf(`{"jsonrpc":"2.0","result":{"n":%d},"id":%s}`, m.Params.N, m.ID)))
+		}
+	}
+	switch {
+	case classify(msg) == kindBatch && resps != nil:
+		ch <- joinBatch(resps)
+	case len(resps) == 1:
+		ch <- resps[0]
+	}
+	return nil
+}
+
+func (b *batchTransport) listen(context.Context, chan<- []byte) {}
+
+func TestProxy_runStdinToPost_batch(t *testing.T) {
+	mixed := `[{"jsonrpc":"2.0","method":"m","params":{"n":1},"id":1},` +
+		`{"jsonrpc":"2.0","method":"notifications/x"},` +
+		`{"jsonrpc":"2.0","method":"m","params":{"n":2},"id":"a"},` +
+		`5]`
+	mixedWant := `[{"jsonrpc":"2.0","result":{"n":1},"id":1},` +
+		`{"jsonrpc":"2.0","result":{"n":2},"id":"a"},` +
+		`{"error":{"code":-32600,"message":"Invalid Request"},"id":null,"jsonrpc":"2.0"}]`
+
+	tests := []struct {
+		name            string
+		protocolVersion string
+		input           string
+		want            []string
+		// wantSent はサーバーへの POST の回数
+		wantSent int
+		// wantBatchSent はサーバーへバッチのまま送ったかどうか
+		wantBatchSent bool
+	}{
+		{"split for servers without batch support", "2025-06-18", mixed, []string{mixedWant}, 3, false},
+		{"forwarded when negotiated 2025-03-26", "2025-03-26", mixed, []string{mixedWant}, 1, true},
+		{"notifications only", "2025-03-26", `[{"jsonrpc":"2.0","method":"notifications/x"}]`, nil, 1, true},
+		{"empty batch", "2025-03-26", `[]`, []string{`{"error":{"code":-32600,"message":"Invalid Request"},"id":null,"jsonrpc":"2.0"}`}, 0, false},
+		{"nested batch", "2025-06-18", `[[]]`, []string{`[{"error":{"code":-32600,"message":"Invalid Request"},"id":null,"jsonrpc":"2.0"}]`}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &batchTransport{}
+			p := &Proxy{
+				cfg:             &config.Config{},
+				stdin:           strings.NewReader(tt.input),
+				calls:           newCallTable(),
+				protocolVersion: tt.protocolVersion,
+			}
+			ch := make(chan []byte, 8)
+			p.runStdinToPost(context.Background(), newShutdown(), tr, ch)
+			close(ch)
+
+			var got []string
+			for b := range ch {
+				got = append(got, string(b))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("stdout = %v, want %v", got, tt.want)
+			}
+			if len(tr.sent) != tt.wantSent {
+				t.Fatalf("sent = %v, want %d POSTs", tr.sent, tt.wantSent)
+			}
+			if tt.wantBatchSent && classify([]byte(tr.sent[0])) != kindBatch {
+				t.Errorf("sent %s, want a batch", tr.sent[0])
+			}
+		})
+	}
+}
+
+func TestProxy_observeInitialize(t *testing.T) {
+	p := &Proxy{cfg: &config.Config{}, calls: newCallTable()}
+	p.observeInitialize([]byte(`{"jsonrpc":"2.0","result":{"protocolVersion":"2025-03-26"},"id":1}`))
+	if !p.supportsBatch() {
+		t.Error("supportsBatch() = false after negotiating 2025-03-26")
+	}
+	p.observeInitialize([]byte(`{"jsonrpc":"2.0","result":{"protocolVersion":"2025-06-18"},"id":1}`))
+	if p.supportsBatch() {
+		t.Error("supportsBatch() = true after negotiating 2025-06-18")
+	}
+}
+
+func TestProxy_Run_serverRequest(t *testing.T) {
+	posted := make(chan string, 4)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: endpoint\ndata: /messages\n\n")
+		fmt.Fprint(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"roots/list\",\"id\":\"s1\"}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		posted <- string(body)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	p := &Proxy{
+		cfg:    &config.Config{URL: srv.URL + "/sse", Transport: config.TransportSSE},
+		client: srv.Client(),
+		stdin:  stdinR,
+		stdout: stdoutW,
+		calls:  newCallTable(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go p.Run(ctx)
+
+	// サーバーからのリクエストがそのまま stdout に届く
+	line, err := bufio.NewReader(stdoutR).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"jsonrpc":"2.0","method":"roots/list","id":"s1"}` + "\n"; line != want {
+		t.Fatalf("stdout = %q, want %q", line, want)
+	}
+
+	// クライアントの応答はサーバーの message エンドポイントへ POST される
+	reply := `{"jsonrpc":"2.0","result":{"roots":[]},"id":"s1"}`
+	fmt.Fprintln(stdinW, reply)
+	select {
+	case got := <-posted:
+		if got != reply {
+			t.Errorf("posted = %s, want %s", got, reply)
+		}
+	case <-ctx.Done():
+		t.Fatal("reply was not posted to the server")
+	}
+}
+
+func TestProxy_dispatch(t *testing.T) {
+	progress := `{"jsonrpc":"2.0","method":"notifications/progress","params":{"progressToken":"t","progress":1}}`
+	logging := `{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"info","data":"x"}}`
+	listChanged := `{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`
+
+	tests := []struct {
+		name   string
+		filter config.NotificationFilter
+		msg    string
+		want   []string
+	}{
+		{"progress", config.NotificationFilter{}, progress, []string{progress}},
+		{"logging", config.NotificationFilter{}, logging, []string{logging}},
+		{"list_changed", config.NotificationFilter{}, listChanged, []string{listChanged}},
+		{"server request", config.NotificationFilter{}, `{"jsonrpc":"2.0","method":"roots/list","id":1}`, []string{`{"jsonrpc":"2.0","method":"roots/list","id":1}`}},
+		{"excluded", config.NotificationFilter{Exclude: []string{"notifications/message"}}, logging, nil},
+		{"not included", config.NotificationFilter{Include: []string{"notifications/progress"}}, listChanged, nil},
+		{"batch is split and filtered", config.NotificationFilter{Include: []string{"notifications/progress"}}, "[" + progress + "," + logging + "]", []string{progress}},
+		{"endpoint event", config.NotificationFilter{}, `{"url":"/mcp"}`, nil},
+		{"response with no matching request", config.NotificationFilter{}, `{"jsonrpc":"2.0","result":{},"id":1}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{cfg: &config.Config{Notifications: tt.filter}, calls: newCallTable()}
+			ch := make(chan []byte, 4)
+			p.dispatch(context.Background(), []byte(tt.msg), ch)
+			close(ch)
+
+			var got []string
+			for b := range ch {
+				got = append(got, string(b))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("forwarded = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_runStdinToPost_tooLarge(t *testing.T) {
+	big := strings.Repeat("x", 300)
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"m","params":{"q":"` + big + `"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/x","params":{"q":"` + big + `"}}`,
+		`{"jsonrpc":"2.0","method":"m","params":{"n":2},"id":2}`,
+	}, "\n")
+	p := &Proxy{
+		cfg:   &config.Config{MaxMessageBytes: 200},
+		stdin: strings.NewReader(input),
+		calls: newCallTable(),
+	}
+	ch := make(chan []byte, 8)
+	p.runStdinToPost(context.Background(), newShutdown(), echoTransport{}, ch)
+	close(ch)
+
+	var got []string
+	for b := range ch {
+		got = append(got, string(b))
+	}
+	want := []string{
+		`{"error":{"code":-32600,"message":"Message too large: exceeds the limit of 200 bytes"},"id":1,"jsonrpc":"2.0"}`,
+		`{"error":{"code":-32600,"message":"Message too large: exceeds the limit of 200 bytes"},"id":null,"jsonrpc":"2.0"}`,
+		`{"jsonrpc":"2.0","result":{"n":2},"id":2}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("stdout = %v, want %v", got, want)
+	}
+}
+
+// gatedWriter は gate が閉じられるまで書き込みを止め、その後は 1 行ごとに少し待つ遅い stdout です。
+type gatedWriter struct {
+	gate  chan struct{}
+	lines chan string
+}
+
+func (w *gatedWriter) Write(b []byte) (int, error) {
+	<-w.gate
+	time.Sleep(20 * time.Microsecond)
+	for _, l := range strings.SplitAfter(string(b), "\n") {
+		if l != "" {
+			w.lines <- l
+		}
+	}
+	return len(b), nil
+}
+
+func TestProxy_Run_slowStdout(t *testing.T) {
+	const n = 2000
+	stdinR, stdinW := io.Pipe()
+	out := &gatedWriter{gate: make(chan struct{}), lines: make(chan string, n)}
+	p := &Proxy{
+		cfg:    &config.Config{MaxMessageBytes: 100},
+		stdin:  stdinR,
+		stdout: out,
+		calls:  newCallTable(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.run(ctx, echoTransport{})
+
+	// リクエスト・壊れた JSON・上限超えのメッセージを混ぜて流し込む。どれにも 1 件ずつレスポンスが返る
+	var written atomic.Int64
+	go func() {
+		defer stdinW.Close()
+		for i := range n {
+			var line string
+			switch i % 10 {
+			case 0:
+				line = `{"jsonrpc":`
+			case 5:
+				line = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"m","params":{"q":"%s"}}`, i, strings.Repeat("x", 100))
+			default:
+				line = fmt.Sprintf(`{"jsonrpc":"2.0","method":"m","params":{"n":%d},"id":%d}`, i, i)
+			}
+			if _, err := fmt.Fprintln(stdinW, line); err != nil {
+				return
+			}
+			written.Add(1)
+		}
+	}()
+
+	// stdout が詰まっている間は stdin の読み込みが止まり、メッセージは捨てられない
+	time.Sleep(100 * time.Millisecond)
+	if w := written.Load(); w >= n {
+		t.Fatalf("all %d lines were read while stdout was blocked", w)
+	}
+	close(out.gate)
+
+	seen := make(map[string]int)
+	for range n {
+		var line string
+		select {
+		case line = <-out.lines:
+		case <-ctx.Done():
+			t.Fatalf("got %d responses, want %d", len(seen), n)
+		}
+		var resp struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("stdout line %q: %v", line, err)
+		}
+		seen[string(resp.ID)]++
+	}
+	if got := seen["null"]; got != n/10 {
+		t.Errorf("%d responses with null id, want %d", got, n/10)
+	}
+	for i := range n {
+		if i%10 == 0 {
+			continue
+		}
+		if got := seen[strconv.Itoa(i)]; got != 1 {
+			t.Errorf("id %d: %d responses, want 1", i, got)
+		}
+	}
+}
+
+// drainTransport は method が "slow" のリクエストに release が閉じられるまで応答しない transport です。
+// listen は ctx が終わるまで戻らず、終わると stopped を閉じます。
+type drainTransport struct {
+	release chan struct{}
+	stopped chan struct{}
+}
+
+func (d *drainTransport) send(ctx context.Context, msg []byte, ch chan<- []byte) error {
+	if requestMethod(msg) == "slow" {
+		select {
+		case <-d.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return echoTransport{}.send(ctx, msg, ch)
+}
+
+func (d *drainTransport) listen(ctx context.Context, _ chan<- []byte) {
+	<-ctx.Done()
+	close(d.stopped)
+}
+
+func TestProxy_run_shutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// closeStdin が true なら stdin を閉じ、false なら Run の ctx をキャンセルして終了させる
+		closeStdin bool
+		// finish が true なら終了処理の開始後に slow リクエストを完了させる
+		finish  bool
+		wantErr error
+		wantOut []string
+	}{
+		{
+			name:       "stdin closed, in-flight request drained",
+			closeStdin: true,
+			finish:     true,
+			wantOut:    []string{`{"jsonrpc":"2.0","result":{"n":1},"id":1}`, `{"jsonrpc":"2.0","result":{"n":2},"id":2}`},
+		},
+		{
+			name:    "cancelled, in-flight request drained",
+			finish:  true,
+			wantErr: context.Canceled,
+			wantOut: []string{`{"jsonrpc":"2.0","result":{"n":1},"id":1}`, `{"jsonrpc":"2.0","result":{"n":2},"id":2}`},
+		},
+		{
+			name:       "stdin closed, grace period expired",
+			closeStdin: true,
+			wantErr:    ErrDrainTimeout,
+			wantOut:    []string{`{"jsonrpc":"2.0","result":{"n":1},"id":1}`},
+		},
+		{
+			name:    "cancelled, grace period expired",
+			wantErr: ErrDrainTimeout,
+			wantOut: []string{`{"jsonrpc":"2.0","result":{"n":1},"id":1}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdinR, stdinW := io.Pipe()
+			stdoutR, stdoutW := io.Pipe()
+			p := &Proxy{
+				cfg:    &config.Config{ShutdownGrace: 200 * time.Millisecond},
+				stdin:  stdinR,
+				stdout: stdoutW,
+				calls:  newCallTable(),
+			}
+			tr := &drainTransport{release: make(chan struct{}), stopped: make(chan struct{})}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- p.run(ctx, tr) }()
+
+			var out []string
+			lines := make(chan string, 8)
+			go func() {
+				sc := bufio.NewScanner(stdoutR)
+				for sc.Scan() {
+					lines <- sc.Text()
+				}
+				close(lines)
+			}()
+
+			fmt.Fprintln(stdinW, `{"jsonrpc":"2.0","method":"m","params":{"n":1},"id":1}`)
+			fmt.Fprintln(stdinW, `{"jsonrpc":"2.0","method":"slow","params":{"n":2},"id":2}`)
+			out = append(out, <-lines)
+			for p.calls.inflight() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			if tt.closeStdin {
+				stdinW.Close()
+			} else {
+				cancel()
+				// 終了処理の開始後に届いた入力は受け付けない
+				time.Sleep(20 * time.Millisecond)
+				go fmt.Fprintln(stdinW, `{"jsonrpc":"2.0","method":"m","params":{"n":3},"id":3}`)
+			}
+			if tt.finish {
+				time.Sleep(20 * time.Millisecond)
+				close(tr.release)
+			}
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("run did not return")
+			}
+			stdoutW.Close()
+			for l := range lines {
+				out = append(out, l)
+			}
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("run() error = %v, want %v", err, tt.wantErr)
+			}
+			if strings.Join(out, "\n") != strings.Join(tt.wantOut, "\n") {
+				t.Errorf("stdout = %q, want %q", out, tt.wantOut)
+			}
+			select {
+			case <-tr.stopped:
+			default:
+				t.Error("server stream was not closed")
+			}
+		})
+	}
+}
+
+// hangTransport は method が hang に含まれるリクエストに、ctx が終わるまで応答しない transport です。
+// サーバーへ送られた notifications/cancelled の params を cancelled に記録します。
+type hangTransport struct {
+	hang map[string]bool
+
+	mu        sync.Mutex
+	cancelled []string
+}
+
+func (h *hangTransport) send(ctx context.Context, msg []byte, ch chan<- []byte) error {
+	method := requestMethod(msg)
+	if method == "notifications/cancelled" {
+		var m struct {
+			Params json.RawMessage `json:"params"`
+		}
+		_ = json.Unmarshal(msg, &m)
+		h.mu.Lock()
+		h.cancelled = append(h.cancelled, string(m.Params))
+		h.mu.Unlock()
+		return nil
+	}
+	if h.hang[method] || h.hang[toolName(msg)] {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return echoTransport{}.send(ctx, msg, ch)
+}
+
+func (h *hangTransport) listen(context.Context, chan<- []byte) {}
+
+func TestProxy_runStdinToPost_timeout(t *testing.T) {
+	timeouts := config.Timeouts{
+		Default: time.Minute,
+		Methods: map[string]time.Duration{"tools/list": 20 * time.Millisecond},
+		Tools:   map[string]time.Duration{"search_documents": 50 * time.Millisecond},
+	}
+	tests := []struct {
+		name        string
+		msg         string
+		wantMin     time.Duration
+		wantResp    string
+		wantCancels []string
+	}{
+		{
+			name:        "method timeout",
+			msg:         `{"jsonrpc":"2.0","method":"tools/list","id":"a"}`,
+			wantMin:     20 * time.Millisecond,
+			wantResp:    `{"error":{"code":-32006,"message":"Timed out after 20ms waiting for the MCP server to respond to tools/list"},"id":"a","jsonrpc":"2.0"}`,
+			wantCancels: []string{`{"reason":"Request timed out after 20ms","requestId":1}`},
+		},
+		{
+			name:        "tool timeout",
+			msg:         `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"search_documents"},"id":7}`,
+			wantMin:     50 * time.Millisecond,
+			wantResp:    `{"error":{"code":-32006,"message":"Timed out after 50ms waiting for the MCP server to respond to tools/call"},"id":7,"jsonrpc":"2.0"}`,
+			wantCancels: []string{`{"reason":"Request timed out after 50ms","requestId":1}`},
+		},
+		{
+			name:     "answered in time",
+			msg:      `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"fast"},"id":8}`,
+			wantResp: `{"jsonrpc":"2.0","result":{"n":0},"id":8}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{
+				cfg:   &config.Config{Timeouts: timeouts},
+				stdin: strings.NewReader(tt.msg + "\n"),
+				calls: newCallTable(),
+			}
+			tr := &hangTransport{hang: map[string]bool{"tools/list": true, "search_documents": true}}
+			ch := make(chan []byte, 4)
+
+			start := time.Now()
+			p.runStdinToPost(context.Background(), newShutdown(), tr, ch)
+			elapsed := time.Since(start)
+			p.cancels.Wait()
+
+			if got := string(<-ch); got != tt.wantResp {
+				t.Errorf("response = %s, want %s", got, tt.wantResp)
+			}
+			if elapsed < tt.wantMin {
+				t.Errorf("responded after %s, want at least %s", elapsed, tt.wantMin)
+			}
+			if strings.Join(tr.cancelled, "\n") != strings.Join(tt.wantCancels, "\n") {
+				t.Errorf("cancellations sent upstream = %q, want %q", tr.cancelled, tt.wantCancels)
+			}
+			if n := p.calls.inflight(); n != 0 {
+				t.Errorf("%d requests left in flight", n)
+			}
+		})
+	}
+}
+
+func TestProxy_runStdinToPost_batchTimeout(t *testing.T) {
+	p := &Proxy{
+		cfg:             &config.Config{Timeouts: config.Timeouts{Default: 20 * time.Millisecond}},
+		stdin:           strings.NewReader(`[{"jsonrpc":"2.0","method":"tools/list","id":1},{"jsonrpc":"2.0","method":"ping","id":2}]` + "\n"),
+		calls:           newCallTable(),
+		protocolVersion: batchProtocolVersion,
+	}
+	ch := make(chan []byte, 4)
+	// バッチの POST 自体が応答しないので、どちらの要素もタイムアウトする
+	tr := &hangTransport{hang: map[string]bool{"": true}}
+	p.runStdinToPost(context.Background(), newShutdown(), tr, ch)
+	p.cancels.Wait()
+
+	got := string(<-ch)
+	if strings.Count(got, `"code":-32006`) != 2 {
+		t.Errorf("batch response = %s, want a timeout error for each element", got)
+	}
+	if len(tr.cancelled) != 2 {
+		t.Errorf("cancellations sent upstream = %q, want one per element", tr.cancelled)
+	}
+}
+
+func TestProxy_runStdinToPost_oneWayTimeout(t *testing.T) {
+	// 応答しない通知の POST はタイムアウトで打ち切られ、後続のメッセージを送って stdin の終わりで戻る
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"notifications/stuck"}`,
+		`{"jsonrpc":"2.0","result":{},"id":"s1"}`,
+	}, "\n") + "\n"
+	p := &Proxy{
+		cfg: &config.Config{Timeouts: config.Timeouts{
+			Default: time.Minute,
+			Methods: map[string]time.Duration{"notifications/stuck": 20 * time.Millisecond},
+		}},
+		stdin: strings.NewReader(input),
+		calls: newCallTable(),
+	}
+	tr := &hangTransport{hang: map[string]bool{"notifications/stuck": true}}
+	done := make(chan struct{})
+	go func() {
+		p.runStdinToPost(context.Background(), newShutdown(), tr, make(chan []byte, 4))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("one-way message without a response blocked the session")
+	}
+}
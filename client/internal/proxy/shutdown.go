@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDrainTimeout は終了時の猶予時間内に実行中のリクエストが終わらず、打ち切ったことを表します。
+var ErrDrainTimeout = errors.New("in-flight requests did not finish within the shutdown grace period")
+
+// sessionCloseTimeout は終了時にサーバーのセッションを閉じるリクエストを待つ時間です。
+const sessionCloseTimeout = 2 * time.Second
+
+// sessionCloser はサーバー側のセッションを明示的に終了できる transport です。
+type sessionCloser interface {
+	// closeSession はサーバーにセッションの終了を伝えます。
+	closeSession(ctx context.Context)
+}
+
+// shutdown はプロキシの終了処理の状態です。
+// 稼働中 → （stdin の EOF または Run の ctx の終了で）入力の受け付けを停止 → 実行中のリクエストの完了を猶予時間まで待つ
+// → ストリームを閉じて終了、の順に進みます。
+type shutdown struct {
+	once sync.Once
+	// begun は入力の受け付けを止めると閉じられる
+	begun chan struct{}
+	// reason は終了のきっかけ。stdin の EOF なら nil、Run の ctx の終了ならそのエラー。
+	reason error
+}
+
+func newShutdown() *shutdown {
+	return &shutdown{begun: make(chan struct{})}
+}
+
+// begin は終了処理を始めます。2 回目以降の呼び出しは無視します。
+func (s *shutdown) begin(reason error) {
+	s.once.Do(func() {
+		s.reason = reason
+		close(s.begun)
+	})
+}
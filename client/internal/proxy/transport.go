@@ -0,0 +1,575 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/sse"
+)
+
+const (
+	// sessionHeader は Streamable HTTP でセッションを識別するヘッダーです。
+	sessionHeader = "Mcp-Session-Id"
+	// protocolVersionHeader は Streamable HTTP で initialize 後に付与するプロトコルバージョンのヘッダーです。
+	protocolVersionHeader = "MCP-Protocol-Version"
+)
+
+// errSessionExpired は Streamable HTTP のセッションが失効した（セッション ID 付きの POST に 404 が返った）ことを表します。
+var errSessionExpired = errors.New("session expired, the client must re-initialize")
+
+// errStreamUnsupported はサーバーが GET によるストリームを提供していない（405）ことを表します。
+var errStreamUnsupported = errors.New("server does not offer an SSE stream")
+
+// transport はサーバーとの JSON-RPC メッセージのやり取りの方式です。
+type transport interface {
+	// send はクライアントからのメッセージを 1 件サーバーへ送り、その応答として返ってきたメッセージを ch に送ります。
+	send(ctx context.Context, msg []byte, ch chan<- []byte) error
+	// listen はサーバーから非同期に届くメッセージを ctx が終わるまで受信し、ch に送ります。
+	listen(ctx context.Context, ch chan<- []byte)
+}
+
+// newTransport は設定の Transport に応じた transport を返します。
+func (p *Proxy) newTransport() (transport, error) {
+	switch p.cfg.Transport {
+	case config.TransportSSE:
+		return newSSETransport(p), nil
+	case config.TransportStreamableHTTP:
+		return newStreamableTransport(p), nil
+	case config.TransportAuto, "":
+		return &autoTransport{
+			p:          p,
+			streamable: newStreamableTransport(p),
+			sse:        newSSETransport(p),
+			decided:    make(chan struct{}),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q", p.cfg.Transport)
+	}
+}
+
+// sseTransport は旧来の HTTP+SSE 方式です。GET /sse でサーバーからのイベントを受け、
+// メッセージはサーバーが endpoint イベントで通知した URL へ POST します。
+type sseTransport struct {
+	p      *Proxy
+	sseURL string
+
+	mu       sync.Mutex
+	postURL  string
+	endpoint chan struct{} // 最初の endpoint イベントを受信すると閉じられる
+	once     sync.Once
+}
+
+func newSSETransport(p *Proxy) *sseTransport {
+	return &sseTransport{
+		p:        p,
+		sseURL:   p.cfg.URL,
+		endpoint: make(chan struct{}),
+	}
+}
+
+// endpointWaitTimeout は送信時に endpoint イベントを待つ最大時間です。
+const endpointWaitTimeout = 30 * time.Second
+
+// messageURL は endpoint イベントで通知された POST 先を返します。まだ受信していない場合は待ちます。
+func (t *sseTransport) messageURL(ctx context.Context) (string, error) {
+	timer := time.NewTimer(endpointWaitTimeout)
+	defer timer.Stop()
+	select {
+	case <-t.endpoint:
+	case <-timer.C:
+		return "", fmt.Errorf("no endpoint event received from %s within %s", t.sseURL, endpointWaitTimeout)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.postURL, nil
+}
+
+// setEndpoint は endpoint イベントの data を SSE の URL 基準で解決し、POST 先として記録します。
+// 再接続でセッション ID が変わった場合も新しい URL で上書きします。
+func (t *sseTransport) setEndpoint(data []byte) error {
+	u, err := resolveEndpoint(t.sseURL, data)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.postURL = u
+	t.mu.Unlock()
+	t.once.Do(func() { close(t.endpoint) })
+	t.p.debugf("endpoint: %s", u)
+	return nil
+}
+
+func (t *sseTransport) send(ctx context.Context, msg []byte, ch chan<- []byte) error {
+	postURL, err := t.messageURL(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := t.p.postMessage(ctx, postURL, msg, nil)
+	if err != nil {
+		return fmt.Errorf("post request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// このリポジトリのサーバーは POST のレスポンスボディで JSON-RPC レスポンスを返す
+		if len(bytes.TrimSpace(body)) > 0 {
+			deliver(ctx, ch, body)
+		}
+		return nil
+	case http.StatusAccepted, http.StatusNoContent:
+		// 仕様どおりのサーバーはレスポンスを SSE ストリームで返す。通知に対してはボディなしで応答する
+		return nil
+	default:
+		t.p.debugf("POST %s status=%d body=%s", postURL, resp.StatusCode, string(body))
+		return newStatusError(resp, body)
+	}
+}
+
+func (t *sseTransport) listen(ctx context.Context, ch chan<- []byte) {
+	_ = t.p.runSSEReceiver(ctx, t.sseURL, nil, func(ev sse.Event) bool {
+		if ev.Type == "endpoint" {
+			if err := t.setEndpoint([]byte(ev.Data)); err != nil {
+				t.p.debugf("ignore endpoint event: %v", err)
+			}
+			return true
+		}
+		return deliver(ctx, ch, []byte(ev.Data))
+	})
+}
+
+// resolveEndpoint は endpoint イベントの data から POST 先の絶対 URL を求めます。
+// data は仕様どおりの URI（例: /messages?sessionId=abc）と、このリポジトリのサーバーが送る JSON（{"url":"/mcp"}）の両方を受け付けます。
+// 認証ヘッダーを別のホストへ送らないよう、SSE の URL と異なるオリジンは拒否します。
+func resolveEndpoint(sseURL string, data []byte) (string, error) {
+	ref := string(bytes.TrimSpace(data))
+	var obj struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(data, &obj); err == nil && obj.URL != "" {
+		ref = obj.URL
+	}
+	if ref == "" {
+		return "", errors.New("empty endpoint")
+	}
+
+	base, err := url.Parse(sseURL)
+	if err != nil {
+		return "", err
+	}
+	rel, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %w", ref, err)
+	}
+	u := base.ResolveReference(rel)
+	if u.Scheme != base.Scheme || u.Host != base.Host {
+		return "", fmt.Errorf("endpoint %q has a different origin from %s", u, sseURL)
+	}
+	return u.String(), nil
+}
+
+// streamableTransport は Streamable HTTP 方式です。単一のエンドポイントへ POST し、
+// レスポンスは application/json または text/event-stream で返ります。セッションは Mcp-Session-Id で維持します。
+type streamableTransport struct {
+	p        *Proxy
+	endpoint string
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+
+	// ready は最初の送信が成功すると閉じられます。listen はこれを待ってから GET ストリームを開きます。
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+func newStreamableTransport(p *Proxy) *streamableTransport {
+	return &streamableTransport{
+		p:        p,
+		endpoint: p.cfg.URL,
+		ready:    make(chan struct{}),
+	}
+}
+
+// header は現在のセッションに応じたリクエストヘッダーを設定します。
+func (t *streamableTransport) header(h http.Header) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessionID != "" {
+		h.Set(sessionHeader, t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		h.Set(protocolVersionHeader, t.protocolVersion)
+	}
+}
+
+func (t *streamableTransport) send(ctx context.Context, msg []byte, ch chan<- []byte) error {
+	t.mu.Lock()
+	hadSession := t.sessionID != ""
+	t.mu.Unlock()
+
+	resp, err := t.p.postMessage(ctx, t.endpoint, msg, t.header)
+	if err != nil {
+		return fmt.Errorf("post request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if sid := resp.Header.Get(sessionHeader); sid != "" {
+		t.mu.Lock()
+		t.sessionID = sid
+		t.mu.Unlock()
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusNoContent:
+		// 通知やレスポンスの受理
+		t.markReady()
+		return nil
+	case resp.StatusCode == http.StatusOK && isEventStream(resp.Header):
+		readSSEStream(ctx, resp.Body, nil, func(ev sse.Event) bool {
+			t.observe(msg, []byte(ev.Data))
+			return deliver(ctx, ch, []byte(ev.Data))
+		})
+		t.markReady()
+		return nil
+	case resp.StatusCode == http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("read response: %w", err)
+		}
+		t.observe(msg, body)
+		if len(bytes.TrimSpace(body)) > 0 {
+			deliver(ctx, ch, body)
+		}
+		t.markReady()
+		return nil
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	t.p.debugf("POST %s status=%d body=%s", t.endpoint, resp.StatusCode, string(body))
+	if resp.StatusCode == http.StatusNotFound && hadSession {
+		// セッションが失効した。次の initialize で新しいセッションを張り直す
+		t.mu.Lock()
+		t.sessionID = ""
+		t.mu.Unlock()
+		return fmt.Errorf("%w: %w", errSessionExpired, newStatusError(resp, body))
+	}
+	return newStatusError(resp, body)
+}
+
+// observe は initialize のレスポンスから合意したプロトコルバージョンを記録します。
+func (t *streamableTransport) observe(req, resp []byte) {
+	if requestMethod(req) != "initialize" {
+		return
+	}
+	version := protocolVersion(resp)
+	if version == "" {
+		return
+	}
+	t.mu.Lock()
+	t.protocolVersion = version
+	t.mu.Unlock()
+}
+
+func (t *streamableTransport) markReady() {
+	t.readyOnce.Do(func() { close(t.ready) })
+}
+
+// listen はセッション確立後に GET でサーバー起点のメッセージ用ストリームを開きます。
+// サーバーが 405 を返した場合（ストリームを提供しない）は何もせずに戻ります。
+func (t *streamableTransport) listen(ctx context.Context, ch chan<- []byte) {
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		return
+	}
+	err := t.p.runSSEReceiver(ctx, t.endpoint, t.header, func(ev sse.Event) bool {
+		return deliver(ctx, ch, []byte(ev.Data))
+	})
+	if errors.Is(err, errStreamUnsupported) {
+		t.p.debugf("server does not offer a GET stream at %s", t.endpoint)
+	}
+}
+
+// closeSession はセッションがあれば DELETE でサーバーに終了を伝えます（Streamable HTTP の仕様）。
+// サーバーが 405 を返す（クライアントからの終了に対応していない）場合を含め、失敗しても何もしません。
+func (t *streamableTransport) closeSession(ctx context.Context) {
+	t.mu.Lock()
+	sid := t.sessionID
+	t.sessionID = ""
+	t.mu.Unlock()
+	if sid == "" {
+		return
+	}
+	resp, err := t.p.do(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		t.header(req.Header)
+		req.Header.Set(sessionHeader, sid)
+		return req, nil
+	})
+	if err != nil {
+		t.p.debugf("DELETE session %s: %v", sid, err)
+		return
+	}
+	resp.Body.Close()
+	t.p.debugf("DELETE session %s status=%d", sid, resp.StatusCode)
+}
+
+// autoTransport は最初のメッセージ（通常は initialize）で Streamable HTTP を試し、
+// サーバーが 400/404/405 を返した場合は旧来の SSE 方式にフォールバックします。
+type autoTransport struct {
+	p          *Proxy
+	streamable *streamableTransport
+	sse        *sseTransport
+
+	mu     sync.Mutex
+	chosen transport
+	// decided は chosen が決まると閉じられます。
+	decided chan struct{}
+}
+
+func (t *autoTransport) send(ctx context.Context, msg []byte, ch chan<- []byte) error {
+	t.mu.Lock()
+	if t.chosen != nil {
+		chosen := t.chosen
+		t.mu.Unlock()
+		return chosen.send(ctx, msg, ch)
+	}
+	// 判定中は他の送信を待たせる
+	defer t.mu.Unlock()
+
+	err := t.streamable.send(ctx, msg, ch)
+	var se *statusError
+	if errors.As(err, &se) && isLegacyStatus(se.code) {
+		t.p.debugf("streamable HTTP probe got status %d, falling back to SSE transport", se.code)
+		t.choose(t.sse)
+		return t.sse.send(ctx, msg, ch)
+	}
+	if err != nil {
+		// 通信エラーなどでは判定できないので次のメッセージで再度試す
+		return err
+	}
+	t.p.debugf("using streamable HTTP transport")
+	t.choose(t.streamable)
+	return nil
+}
+
+func (t *autoTransport) choose(tr transport) {
+	t.chosen = tr
+	close(t.decided)
+}
+
+func (t *autoTransport) listen(ctx context.Context, ch chan<- []byte) {
+	select {
+	case <-t.decided:
+	case <-ctx.Done():
+		return
+	}
+	// chosen は decided を閉じる前に書き込まれ、以降は変更されない。
+	// 判定中の send がロックを保持したまま endpoint を待つことがあるので、ここではロックを取らない。
+	t.chosen.listen(ctx, ch)
+}
+
+// closeSession は判定済みの transport がセッションを持っていれば閉じます。
+func (t *autoTransport) closeSession(ctx context.Context) {
+	select {
+	case <-t.decided:
+	default:
+		return
+	}
+	if c, ok := t.chosen.(sessionCloser); ok {
+		c.closeSession(ctx)
+	}
+}
+
+// isLegacyStatus は Streamable HTTP の POST に対し、旧来の SSE サーバーが返すステータスかどうかを判定します。
+func isLegacyStatus(code int) bool {
+	return code == http.StatusBadRequest || code == http.StatusNotFound || code == http.StatusMethodNotAllowed
+}
+
+// postMessage は msg を target へ POST します。header が nil でなければ追加のヘッダーを設定します。
+// ctx に相関 ID が載っていれば X-Request-Id として送ります。
+func (p *Proxy) postMessage(ctx context.Context, target string, msg []byte, header func(http.Header)) (*http.Response, error) {
+	return p.do(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(msg))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		if id := correlationID(ctx); id != "" {
+			req.Header.Set(correlationHeader, id)
+		}
+		if header != nil {
+			header(req.Header)
+		}
+		return req, nil
+	})
+}
+
+// SSE ストリームの再接続の待ち時間です。失敗が続くと defaultRetryBase から倍々に延ばし、defaultRetryMax で頭打ちにします。
+// サーバーが retry フィールドを送った場合は defaultRetryBase の代わりにその値を使います。
+const (
+	defaultRetryBase = time.Second
+	defaultRetryMax  = 30 * time.Second
+)
+
+// runSSEReceiver は GET streamURL でストリームを受け、各イベントを fn に渡します。切断されたら再接続します。
+// 再接続の間隔はジッター付きの指数バックオフで、最後に受け取ったイベント ID を Last-Event-ID で送り、
+// サーバーが取りこぼしたメッセージを再送できるようにします。
+// ctx が終了するか、サーバーが 405 を返した場合（errStreamUnsupported）に戻ります。
+func (p *Proxy) runSSEReceiver(ctx context.Context, streamURL string, header func(http.Header), fn func(ev sse.Event) bool) error {
+	var st sseState
+	bo := backoff{base: p.retryBase, max: defaultRetryMax}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		resp, err := p.do(func() (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Accept", "text/event-stream")
+			if st.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", st.lastEventID)
+			}
+			if header != nil {
+				header(req.Header)
+			}
+			return req, nil
+		})
+		if err != nil {
+			d := bo.next(st.retry)
+			p.debugf("SSE request error: %v (retry in %s)", err, d)
+			sleepCtx(ctx, d)
+			continue
+		}
+
+		if resp.StatusCode == http.StatusMethodNotAllowed {
+			resp.Body.Close()
+			return errStreamUnsupported
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			d := bo.next(st.retry)
+			p.debugf("SSE status=%d (retry in %s)", resp.StatusCode, d)
+			sleepCtx(ctx, d)
+			continue
+		}
+
+		if readSSEStream(ctx, resp.Body, &st, fn) > 0 {
+			// イベントを受け取れた接続の後は待ち時間を初期値に戻す
+			bo.reset()
+		}
+		resp.Body.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		d := bo.next(st.retry)
+		p.debugf("SSE stream closed (last event id %q, reconnect in %s)", st.lastEventID, d)
+		sleepCtx(ctx, d)
+	}
+}
+
+// backoff は再接続の待ち時間を求めます。
+type backoff struct {
+	// base は最初の待ち時間。0 の場合は defaultRetryBase を使う。
+	base time.Duration
+	// max は待ち時間の上限。0 の場合は defaultRetryMax を使う。
+	max     time.Duration
+	attempt int
+}
+
+// next は次の待ち時間を返します。serverRetry が 0 より大きければ base の代わりに使います。
+// 待ち時間は base * 2^attempt（max で頭打ち）の半分から全体までの間でランダムに決めます。
+func (b *backoff) next(serverRetry time.Duration) time.Duration {
+	base, max := b.base, b.max
+	if base <= 0 {
+		base = defaultRetryBase
+	}
+	if serverRetry > 0 {
+		base = serverRetry
+	}
+	if max <= 0 {
+		max = defaultRetryMax
+	}
+	d := base
+	for i := 0; i < b.attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	b.attempt++
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int64N(int64(d-half)+1))
+}
+
+// reset は待ち時間を初期値に戻します。
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// deliver はサーバーから受け取った JSON-RPC メッセージを ch に送ります。ctx が終了した場合は false を返します。
+func deliver(ctx context.Context, ch chan<- []byte, data []byte) bool {
+	select {
+	case ch <- data:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isEventStream は Content-Type が text/event-stream かどうかを判定します。
+func isEventStream(h http.Header) bool {
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && mt == "text/event-stream"
+}
+
+// requestMethod は JSON-RPC メッセージの method を返します。パースできない場合は空文字列を返します。
+func requestMethod(msg []byte) string {
+	var m struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return ""
+	}
+	return m.Method
+}
+
+// sleepCtx は d だけ待ちます。ctx が終了した場合はすぐに戻ります。
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,417 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/config"
+	"github.com/otajisan/vertex-ai-search-mcp-prototype/client/internal/sse"
+)
+
+// newLegacyServer はこのリポジトリの Kotlin サーバーと同じ形（GET /sse と POST /mcp）のテスト用サーバーを起動します。
+func newLegacyServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event:endpoint\ndata:{\"url\":\"/mcp\"}\n\n")
+	})
+	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &req)
+		if req.ID == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","result":{"method":%q},"id":%s}`, req.Method, req.ID)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newStreamableServer は Streamable HTTP のテスト用サーバーを起動します。
+// initialize で Mcp-Session-Id を発行し、それ以降のリクエストには SSE でレスポンスを返します。
+func newStreamableServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &req)
+
+		if req.Method == "initialize" {
+			w.Header().Set(sessionHeader, "session-1")
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","result":{"protocolVersion":"2025-06-18"},"id":%s}`, req.ID)
+			return
+		}
+		if r.Header.Get(sessionHeader) != "session-1" || r.Header.Get(protocolVersionHeader) != "2025-06-18" {
+			http.Error(w, "missing session", http.StatusBadRequest)
+			return
+		}
+		if req.ID == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"result\":{\"method\":%q},\"id\":%s}\n\n", req.Method, req.ID)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTransport_send(t *testing.T) {
+	legacy := newLegacyServer(t)
+	streamable := newStreamableServer(t)
+
+	tests := []struct {
+		name      string
+		url       string
+		transport string
+		// wantChosen は auto の場合に選ばれるべき transport の型名
+		wantChosen string
+	}{
+		{"sse", legacy.URL + "/sse", config.TransportSSE, ""},
+		{"streamable-http", streamable.URL + "/mcp", config.TransportStreamableHTTP, ""},
+		{"auto falls back to sse", legacy.URL + "/sse", config.TransportAuto, "*proxy.sseTransport"},
+		{"auto picks streamable-http", streamable.URL + "/mcp", config.TransportAuto, "*proxy.streamableTransport"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{cfg: &config.Config{URL: tt.url, Transport: tt.transport}, client: http.DefaultClient}
+			tr, err := p.newTransport()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			ch := make(chan []byte, 8)
+			go tr.listen(ctx, ch)
+			msgs := []string{
+				`{"jsonrpc":"2.0","method":"initialize","params":{},"id":1}`,
+				`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+				`{"jsonrpc":"2.0","method":"tools/list","id":"two"}`,
+			}
+			for _, msg := range msgs {
+				if err := tr.send(ctx, []byte(msg), ch); err != nil {
+					t.Fatalf("send(%s) error = %v", msg, err)
+				}
+			}
+
+			want := []string{`1`, `"two"`}
+			for _, id := range want {
+				select {
+				case got := <-ch:
+					var m struct {
+						ID json.RawMessage `json:"id"`
+					}
+					if err := json.Unmarshal(got, &m); err != nil || string(m.ID) != id {
+						t.Errorf("response = %s, want id %s", got, id)
+					}
+				case <-ctx.Done():
+					t.Fatalf("no response for id %s", id)
+				}
+			}
+
+			if tt.wantChosen != "" {
+				auto := tr.(*autoTransport)
+				if got := fmt.Sprintf("%T", auto.chosen); got != tt.wantChosen {
+					t.Errorf("chosen = %s, want %s", got, tt.wantChosen)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamableTransport_sessionExpired(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(sessionHeader) != "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set(sessionHeader, "session-1")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"jsonrpc":"2.0","result":{},"id":1}`)
+	}))
+	defer srv.Close()
+
+	p := &Proxy{cfg: &config.Config{URL: srv.URL}, client: http.DefaultClient}
+	tr := newStreamableTransport(p)
+	ch := make(chan []byte, 4)
+	ctx := context.Background()
+	if err := tr.send(ctx, []byte(`{"jsonrpc":"2.0","method":"initialize","id":1}`), ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.send(ctx, []byte(`{"jsonrpc":"2.0","method":"tools/list","id":2}`), ch); err == nil {
+		t.Fatal("send() expected error for expired session")
+	}
+	if tr.sessionID != "" {
+		t.Errorf("sessionID = %q, want cleared after 404", tr.sessionID)
+	}
+}
+
+func TestStreamableTransport_closeSession(t *testing.T) {
+	deleted := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted <- r.Header.Get(sessionHeader)
+			return
+		}
+		w.Header().Set(sessionHeader, "session-1")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"jsonrpc":"2.0","result":{},"id":1}`)
+	}))
+	defer srv.Close()
+
+	p := &Proxy{cfg: &config.Config{URL: srv.URL}, client: http.DefaultClient}
+	tr := newStreamableTransport(p)
+	ctx := context.Background()
+
+	// セッションが無ければ DELETE は送らない
+	tr.closeSession(ctx)
+	if err := tr.send(ctx, []byte(`{"jsonrpc":"2.0","method":"initialize","id":1}`), make(chan []byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	tr.closeSession(ctx)
+	tr.closeSession(ctx)
+	close(deleted)
+
+	var got []string
+	for sid := range deleted {
+		got = append(got, sid)
+	}
+	if len(got) != 1 || got[0] != "session-1" {
+		t.Errorf("DELETE requests with session %q, want exactly one for session-1", got)
+	}
+}
+
+// newSpecSSEServer は MCP 仕様どおりの HTTP+SSE サーバーを起動します。
+// GET /sse を開いたままにし、endpoint イベントでセッション ID 付きの POST 先を通知し、レスポンスは SSE で返します。
+func newSpecSSEServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	streams := make(chan chan []byte, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", func(w http.ResponseWriter, r *http.Request) {
+		out := make(chan []byte, 8)
+		streams <- out
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: endpoint\ndata: /messages?sessionId=abc\n\n")
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case msg := <-out:
+				fmt.Fprintf(w, "event: message\ndata: %s\n\n", msg)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	var out chan []byte
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sessionId") != "abc" {
+			http.Error(w, "unknown session", http.StatusNotFound)
+			return
+		}
+		if out == nil {
+			out = <-streams
+		}
+		var req struct {
+			ID json.RawMessage `json:"id"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &req)
+		w.WriteHeader(http.StatusAccepted)
+		if req.ID != nil {
+			out <- []byte(fmt.Sprintf(`{"jsonrpc":"2.0","result":{},"id":%s}`, req.ID))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSSETransport_endpointEvent(t *testing.T) {
+	srv := newSpecSSEServer(t)
+	p := &Proxy{cfg: &config.Config{URL: srv.URL + "/sse", Transport: config.TransportSSE}, client: http.DefaultClient}
+	tr := newSSETransport(p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch := make(chan []byte, 4)
+	go tr.listen(ctx, ch)
+
+	if err := tr.send(ctx, []byte(`{"jsonrpc":"2.0","method":"initialize","id":7}`), ch); err != nil {
+		t.Fatalf("send() error = %v", err)
+	}
+	select {
+	case got := <-ch:
+		if string(got) != `{"jsonrpc":"2.0","result":{},"id":7}` {
+			t.Errorf("response = %s", got)
+		}
+	case <-ctx.Done():
+		t.Fatal("no response over SSE")
+	}
+	if want := srv.URL + "/messages?sessionId=abc"; tr.postURL != want {
+		t.Errorf("postURL = %q, want %q", tr.postURL, want)
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		sseURL  string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{"json url", "http://localhost:8080/sse", `{"url":"/mcp"}`, "http://localhost:8080/mcp", false},
+		{"relative with session", "http://localhost:8080/sse", "/messages?sessionId=abc", "http://localhost:8080/messages?sessionId=abc", false},
+		{"relative to sub path", "https://api.example.com/mcp/sse", "messages?session_id=1", "https://api.example.com/mcp/messages?session_id=1", false},
+		{"same origin absolute", "https://api.example.com/sse", "https://api.example.com/rpc", "https://api.example.com/rpc", false},
+		{"different origin", "https://api.example.com/sse", "https://evil.example.com/rpc", "", true},
+		{"empty", "http://localhost:8080/sse", "  ", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveEndpoint(tt.sseURL, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveEndpoint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSSEStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		stream    string
+		resumeID  string
+		want      []string
+		wantID    string
+		wantRetry time.Duration
+	}{
+		{"data", "event: message\ndata: {\"a\":1}\n\n", "", []string{`{"a":1}`}, "", 0},
+		{"empty data is skipped", "data:\n\ndata: x\n\n", "", []string{"x"}, "", 0},
+		{"id without data is remembered", "id: 9\n\n", "", nil, "9", 0},
+		{"resumed id is kept", "data: x\n\n", "5", []string{"x"}, "5", 0},
+		{"retry", "retry: 1500\n\n", "", nil, "", 1500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := sseState{lastEventID: tt.resumeID}
+			var got []string
+			n := readSSEStream(context.Background(), strings.NewReader(tt.stream), &st, func(ev sse.Event) bool {
+				got = append(got, ev.Data)
+				return true
+			})
+			if n != len(got) || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("readSSEStream() = %d, events = %q, want %q", n, got, tt.want)
+			}
+			if st.lastEventID != tt.wantID {
+				t.Errorf("lastEventID = %q, want %q", st.lastEventID, tt.wantID)
+			}
+			if st.retry != tt.wantRetry {
+				t.Errorf("retry = %s, want %s", st.retry, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := backoff{base: 100 * time.Millisecond, max: time.Second}
+	for i, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		if got := b.next(0); got < want/2 || got > want {
+			t.Errorf("attempt %d: next() = %s, want between %s and %s", i, got, want/2, want)
+		}
+	}
+	b.reset()
+	if got := b.next(20 * time.Millisecond); got < 10*time.Millisecond || got > 20*time.Millisecond {
+		t.Errorf("after reset with server retry: next() = %s, want between 10ms and 20ms", got)
+	}
+}
+
+func TestRunSSEReceiver_reconnect(t *testing.T) {
+	var mu sync.Mutex
+	var lastEventIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		conn := len(lastEventIDs)
+		mu.Unlock()
+
+		switch conn {
+		case 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 2:
+			// 2 件送った後に接続を切る
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "retry: 10\n\nid: 1\ndata: {\"n\":1}\n\nid: 2\ndata: {\"n\":2}\n\n")
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			if r.Header.Get("Last-Event-ID") == "2" {
+				fmt.Fprint(w, "id: 3\ndata: {\"n\":3}\n\n")
+			}
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	p := &Proxy{cfg: &config.Config{}, client: srv.Client(), retryBase: 10 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var got []string
+	done := make(chan error, 1)
+	go func() {
+		done <- p.runSSEReceiver(ctx, srv.URL, nil, func(ev sse.Event) bool {
+			got = append(got, ev.Data)
+			if len(got) == 3 {
+				cancel()
+			}
+			return true
+		})
+	}()
+	<-done
+
+	if strings.Join(got, ",") != `{"n":1},{"n":2},{"n":3}` {
+		t.Errorf("events = %v, want n 1 to 3 across the reconnect", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lastEventIDs) != 3 || lastEventIDs[0] != "" || lastEventIDs[1] != "" || lastEventIDs[2] != "2" {
+		t.Errorf("Last-Event-ID per connection = %q, want [\"\" \"\" \"2\"]", lastEventIDs)
+	}
+}
@@ -0,0 +1,179 @@
+// Package sse は Server-Sent Events（text/event-stream）のストリームを WHATWG HTML Living Standard の
+// 「9.2 Server-sent events」に従ってイベントに分解します。
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event は SSE の 1 イベントです。
+type Event struct {
+	// Type は event フィールドの値。指定がない場合は "message"。
+	Type string
+	// Data は data フィールドの値。複数の data 行は改行で連結される。
+	Data string
+	// ID はこのイベントを受け取った時点の最後のイベント ID（id フィールドは後続のイベントにも引き継がれる）。
+	ID string
+}
+
+// bom は UTF-8 の BOM です。ストリームの先頭にあれば読み飛ばします。
+var bom = []byte("\xEF\xBB\xBF")
+
+// Decoder は io.Reader から SSE のイベントを順に読み出します。1 行や 1 イベントの大きさに上限はありません。
+type Decoder struct {
+	r *bufio.Reader
+
+	// started は最初の行を読んだかどうか（BOM の判定用）
+	started bool
+	// skipLF は直前の行が CR で終わったことを表す。CRLF の LF を次の行の先頭で読み飛ばす。
+	skipLF bool
+
+	eventType   string
+	data        strings.Builder
+	hasData     bool
+	lastEventID string
+	retry       time.Duration
+	retrySet    bool
+}
+
+// NewDecoder は r を読む Decoder を返します。
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// SetLastEventID は最後のイベント ID の初期値を設定します。再接続後のストリームに前回の ID を引き継ぐ場合に使います。
+func (d *Decoder) SetLastEventID(id string) {
+	d.lastEventID = id
+}
+
+// LastEventID は最後に受け取ったイベント ID を返します。イベントを伴わない id フィールドも反映されます。
+func (d *Decoder) LastEventID() string {
+	return d.lastEventID
+}
+
+// Retry はサーバーが retry フィールドで指定した再接続の待ち時間を返します。指定がない場合は ok に false を返します。
+func (d *Decoder) Retry() (retry time.Duration, ok bool) {
+	return d.retry, d.retrySet
+}
+
+// Next は次のイベントを返します。ストリームが終わると io.EOF を返します。
+// 空行で終わっていない最後のイベントは仕様どおり捨てます。
+func (d *Decoder) Next() (Event, error) {
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return Event{}, err
+		}
+		if len(line) == 0 {
+			if ev, ok := d.dispatch(); ok {
+				return ev, nil
+			}
+			continue
+		}
+		d.processLine(line)
+	}
+}
+
+// dispatch は溜めた data からイベントを作り、バッファを空にします。data が無い場合は ok に false を返します。
+func (d *Decoder) dispatch() (Event, bool) {
+	defer func() {
+		d.eventType = ""
+		d.data.Reset()
+		d.hasData = false
+	}()
+	if !d.hasData {
+		return Event{}, false
+	}
+	ev := Event{
+		Type: d.eventType,
+		Data: strings.TrimSuffix(d.data.String(), "\n"),
+		ID:   d.lastEventID,
+	}
+	if ev.Type == "" {
+		ev.Type = "message"
+	}
+	return ev, true
+}
+
+// processLine は空でない 1 行を解釈します。
+func (d *Decoder) processLine(line []byte) {
+	if line[0] == ':' {
+		// コメント
+		return
+	}
+	field, value, found := bytes.Cut(line, []byte(":"))
+	if found {
+		value = bytes.TrimPrefix(value, []byte(" "))
+	}
+
+	switch string(field) {
+	case "event":
+		d.eventType = string(value)
+	case "data":
+		d.data.Write(value)
+		d.data.WriteByte('\n')
+		d.hasData = true
+	case "id":
+		if bytes.IndexByte(value, 0) < 0 {
+			d.lastEventID = string(value)
+		}
+	case "retry":
+		if isDigits(value) {
+			if ms, err := strconv.ParseInt(string(value), 10, 64); err == nil && ms <= int64(time.Duration(1<<63-1)/time.Millisecond) {
+				d.retry = time.Duration(ms) * time.Millisecond
+				d.retrySet = true
+			}
+		}
+	}
+}
+
+// readLine は CRLF・LF・CR のいずれかで終わる 1 行を、終端を除いて返します。
+// 終端の無いまま EOF になった行は返さず、io.EOF を返します。
+func (d *Decoder) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		if _, err := d.r.Peek(1); err != nil {
+			return nil, err
+		}
+		chunk, _ := d.r.Peek(d.r.Buffered())
+		if d.skipLF {
+			d.skipLF = false
+			if chunk[0] == '\n' {
+				d.r.Discard(1)
+				continue
+			}
+		}
+
+		i := bytes.IndexAny(chunk, "\r\n")
+		if i < 0 {
+			line = append(line, chunk...)
+			d.r.Discard(len(chunk))
+			continue
+		}
+		line = append(line, chunk[:i]...)
+		// CR の直後の LF はまだ届いていないことがあるので、次の行の先頭で読み飛ばす
+		d.skipLF = chunk[i] == '\r'
+		d.r.Discard(i + 1)
+
+		if !d.started {
+			d.started = true
+			line = bytes.TrimPrefix(line, bom)
+		}
+		return line, nil
+	}
+}
+
+// isDigits は b が空でなく ASCII の数字だけからなるかどうかを判定します。
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
@@ -0,0 +1,175 @@
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// golden は testdata/<name>.golden の内容で、<name>.sse を読み切った結果です。
+type golden struct {
+	Events      []Event `json:"events"`
+	LastEventID string  `json:"lastEventID"`
+	RetryMillis int64   `json:"retryMillis"`
+}
+
+// decodeAll は r を最後まで読み、得られたイベントを返します。
+func decodeAll(t testing.TB, d *Decoder) []Event {
+	t.Helper()
+	var events []Event
+	for {
+		ev, err := d.Next()
+		if errors.Is(err, io.EOF) {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestDecoder_testdata(t *testing.T) {
+	streams, err := filepath.Glob(filepath.Join("testdata", "*.sse"))
+	if err != nil || len(streams) == 0 {
+		t.Fatalf("no testdata streams: %v", err)
+	}
+	for _, path := range streams {
+		name := strings.TrimSuffix(filepath.Base(path), ".sse")
+		t.Run(name, func(t *testing.T) {
+			stream, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(strings.TrimSuffix(path, ".sse") + ".golden")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want golden
+			if err := json.Unmarshal(b, &want); err != nil {
+				t.Fatal(err)
+			}
+
+			readers := map[string]func() io.Reader{
+				"whole":    func() io.Reader { return strings.NewReader(string(stream)) },
+				"one byte": func() io.Reader { return iotest.OneByteReader(strings.NewReader(string(stream))) },
+			}
+			for rname, newReader := range readers {
+				d := NewDecoder(newReader())
+				got := decodeAll(t, d)
+				if !reflect.DeepEqual(got, want.Events) {
+					t.Errorf("%s: events = %q, want %q", rname, got, want.Events)
+				}
+				if d.LastEventID() != want.LastEventID {
+					t.Errorf("%s: LastEventID() = %q, want %q", rname, d.LastEventID(), want.LastEventID)
+				}
+				retry, ok := d.Retry()
+				if ok != (want.RetryMillis > 0) || retry != time.Duration(want.RetryMillis)*time.Millisecond {
+					t.Errorf("%s: Retry() = %s, %v, want %dms", rname, retry, ok, want.RetryMillis)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoder_largeEvent(t *testing.T) {
+	// bufio.Scanner の既定の上限（64KB）や以前の 1MB の上限を超えるイベント
+	line := strings.Repeat("x", 3<<20)
+	d := NewDecoder(strings.NewReader("data: " + line + "\ndata: " + line + "\n\n"))
+	ev, err := d.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	if ev.Data != line+"\n"+line {
+		t.Errorf("len(Data) = %d, want %d", len(ev.Data), 2*len(line)+1)
+	}
+}
+
+func TestDecoder_SetLastEventID(t *testing.T) {
+	d := NewDecoder(strings.NewReader("data: a\n\nid: 8\ndata: b\n\n"))
+	d.SetLastEventID("7")
+	got := decodeAll(t, d)
+	want := []Event{{Type: "message", Data: "a", ID: "7"}, {Type: "message", Data: "b", ID: "8"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+// FuzzDecoder は任意のストリームでパニックしないこと、読み込みの区切り方に結果が左右されないこと、
+// イベントが仕様上の性質（Type が空でない、Data に CR を含まない）を満たすことを確かめます。
+func FuzzDecoder(f *testing.F) {
+	streams, _ := filepath.Glob(filepath.Join("testdata", "*.sse"))
+	for _, path := range streams {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		whole := NewDecoder(strings.NewReader(string(stream)))
+		got := decodeAll(t, whole)
+		oneByte := NewDecoder(iotest.OneByteReader(strings.NewReader(string(stream))))
+		if again := decodeAll(t, oneByte); !reflect.DeepEqual(got, again) {
+			t.Fatalf("one byte reader: events = %q, want %q", again, got)
+		}
+		if whole.LastEventID() != oneByte.LastEventID() {
+			t.Fatalf("LastEventID() differs: %q vs %q", whole.LastEventID(), oneByte.LastEventID())
+		}
+		for _, ev := range got {
+			if ev.Type == "" {
+				t.Errorf("event with empty Type: %q", ev)
+			}
+			if strings.ContainsRune(ev.Data, '\r') {
+				t.Errorf("Data contains CR: %q", ev.Data)
+			}
+		}
+	})
+}
+
+// FuzzDecoder_roundTrip は、改行を含む任意のデータを仕様どおりに data 行へ分けて書いたストリームから、
+// 元のデータ（改行は LF に正規化）が復元されることを確かめます。
+func FuzzDecoder_roundTrip(f *testing.F) {
+	f.Add("message", `{"jsonrpc":"2.0","result":{},"id":1}`, "1", "\n")
+	f.Add("endpoint", "/messages?sessionId=abc", "", "\r\n")
+	f.Add("", "{\n  \"a\": [1,\r\n2]\r}", "x", "\r")
+	f.Add("message", " leading and trailing ", "", "\n")
+	f.Fuzz(func(t *testing.T, typ, data, id, eol string) {
+		if eol != "\n" && eol != "\r\n" && eol != "\r" {
+			t.Skip()
+		}
+		if strings.ContainsAny(typ, "\r\n") || strings.ContainsAny(id, "\r\n\x00") {
+			t.Skip()
+		}
+
+		var b strings.Builder
+		if typ != "" {
+			b.WriteString("event: " + typ + eol)
+		}
+		if id != "" {
+			b.WriteString("id: " + id + eol)
+		}
+		lines := strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(data), "\n")
+		for _, l := range lines {
+			b.WriteString("data: " + l + eol)
+		}
+		b.WriteString(eol)
+
+		got := decodeAll(t, NewDecoder(strings.NewReader(b.String())))
+		wantType := typ
+		if wantType == "" {
+			wantType = "message"
+		}
+		want := []Event{{Type: wantType, Data: strings.Join(lines, "\n"), ID: id}}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("stream %q: events = %q, want %q", b.String(), got, want)
+		}
+	})
+}